@@ -28,6 +28,20 @@ func main() {
 			return
 		}
 
+	case "-sync":
+		{
+			serveSyncResult()
+
+			return
+		}
+
+	case "-covers":
+		{
+			serveCoverMaintenance()
+
+			return
+		}
+
 	case "-library":
 		{
 
@@ -39,6 +53,16 @@ func main() {
 			SearchBookDatabase(argString)
 		}
 
+	case "-merged":
+		{
+			searchMerged(argString)
+		}
+
+	case "-prefetch":
+		{
+			prefetchCatalog(argString)
+		}
+
 	case "-removeBook":
 		{
 			deleteLibraryBook()
@@ -58,7 +82,7 @@ func main() {
 		}
 	case "-byStatus":
 		{
-			fetchServeStatus()
+			fetchServeStatus(argString)
 		}
 	case "-toggleShelf":
 		{
@@ -66,7 +90,27 @@ func main() {
 		}
 	case "-changeStatus":
 		{
-			changeBookStatus()
+			changeBookStatus(argString)
+		}
+	case "-progress":
+		{
+			setReadingProgress(argString)
+		}
+	case "-progressInput":
+		{
+			serveProgressInput(argString)
+		}
+	case "-reread":
+		{
+			startReread(argString)
+		}
+	case "-rereadInput":
+		{
+			serveRereadInput(argString)
+		}
+	case "-history":
+		{
+			serveReadHistory()
 		}
 	}
 
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Book details for library and catalog results: Large Type (⌘L) and copy
+// (⌘C) show the description, and Quick Look (⇧) opens a local HTML page
+// written to the details folder of the workflow data. Only the first
+// results of a search get a page; -covers removes the pages of books no
+// longer in the library.
+
+// detailsPageLimit is the number of results per run that get a Quick Look
+// page, the ones Alfred shows first; the others open the Hardcover page
+const detailsPageLimit = 20
+
+// detailsPagesWritten counts the pages of this run
+var detailsPagesWritten int
+
+// bookDetails is what the detail views show of a book
+type bookDetails struct {
+	ID          int
+	Title       string
+	Authors     string
+	ReleaseYear int
+	Rating      string // community rating, formatted
+	Description string
+	CoverPath   string
+	URL         string // Hardcover page
+	Library     string // reading status, rating of mine and shelves
+}
+
+// headline is the title line of the text views
+func (d bookDetails) headline() string {
+	line := d.Title
+	if d.Authors != "" {
+		line += " — " + d.Authors
+	}
+	if d.ReleaseYear != 0 {
+		line += " (" + strconv.Itoa(d.ReleaseYear) + ")"
+	}
+	return line
+}
+
+// alfredText is the text payload of an Alfred item
+func (d bookDetails) alfredText() map[string]string {
+	largeType := d.headline()
+	if d.Description != "" {
+		largeType += "\n\n" + d.Description
+	}
+	return map[string]string{
+		"largetype": largeType,
+		"copy":      largeType + "\n\n" + d.URL,
+	}
+}
+
+// descriptionParagraphs splits a description at its line breaks
+func descriptionParagraphs(description string) []string {
+	var paragraphs []string
+	for _, line := range strings.Split(strings.ReplaceAll(description, "\r\n", "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paragraphs = append(paragraphs, line)
+		}
+	}
+	return paragraphs
+}
+
+var detailsPage = template.Must(template.New("details").Funcs(template.FuncMap{
+	"paragraphs": descriptionParagraphs,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+	body { font: 15px/1.5 -apple-system, sans-serif; margin: 2em; color: #222; background: #fff; }
+	@media (prefers-color-scheme: dark) { body { color: #ddd; background: #1e1e1e; } a { color: #8ab4f8; } }
+	img { float: left; max-width: 160px; margin: 0 1.5em 1em 0; border-radius: 4px; }
+	h1 { font-size: 1.6em; margin: 0; }
+	.meta { color: #888; margin: 0.3em 0 1em; }
+</style>
+</head>
+<body>
+{{if .CoverPath}}<img src="{{.CoverPath}}" alt="">{{end}}
+<h1>{{.Title}}</h1>
+<p class="meta">{{.Authors}}{{if .ReleaseYear}} · {{.ReleaseYear}}{{end}}{{if .Rating}} · {{.Rating}}{{end}}</p>
+{{if .Library}}<p>{{.Library}}</p>{{end}}
+{{range paragraphs .Description}}<p>{{.}}</p>
+{{else}}<p class="meta">No description.</p>
+{{end}}
+<p><a href="{{.URL}}">Open on Hardcover</a></p>
+</body>
+</html>
+`))
+
+// writeDetailsPage writes the Quick Look page of a book and returns its
+// path; unchanged pages are not rewritten
+func writeDetailsPage(d bookDetails) (string, error) {
+	var page bytes.Buffer
+	if err := detailsPage.Execute(&page, d); err != nil {
+		return "", fmt.Errorf("failed to render details of book %d: %w", d.ID, err)
+	}
+
+	pagePath := filepath.Join(detailsDir, strconv.Itoa(d.ID)+".html")
+	if existing, err := os.ReadFile(pagePath); err == nil && bytes.Equal(existing, page.Bytes()) {
+		return pagePath, nil
+	}
+	if err := os.WriteFile(pagePath, page.Bytes(), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write details of book %d: %w", d.ID, err)
+	}
+	return pagePath, nil
+}
+
+// addDetails adds the text payloads and the Quick Look page to an item
+func addDetails(item map[string]interface{}, d bookDetails) {
+	item["text"] = d.alfredText()
+	if detailsPagesWritten >= detailsPageLimit {
+		item["quicklookurl"] = d.URL
+		return
+	}
+	detailsPagesWritten++
+	pagePath, err := writeDetailsPage(d)
+	if err != nil {
+		// Quick Look falls back to the Hardcover page
+		LogF("%v", err)
+		item["quicklookurl"] = d.URL
+		return
+	}
+	item["quicklookurl"] = pagePath
+}
+
+// pruneDetailsPages removes the pages of books not in the library (catalog
+// pages are written again when shown) and returns how many it removed
+func pruneDetailsPages(libraryBooks map[int]BookInfoMap) (int, error) {
+	entries, err := os.ReadDir(detailsDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read details directory: %w", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		bookID, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".html"))
+		if entry.IsDir() || err != nil {
+			continue
+		}
+		if _, inLibrary := libraryBooks[bookID]; inLibrary {
+			continue
+		}
+		if err := os.Remove(filepath.Join(detailsDir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBookDetails(t *testing.T) {
+	detailsDir = t.TempDir()
+	detailsPagesWritten = 0
+	t.Cleanup(func() { detailsPagesWritten = 0 })
+	details := bookDetails{
+		ID:          42,
+		Title:       "Dune",
+		Authors:     "Frank Herbert",
+		ReleaseYear: 1965,
+		Description: "Set on the desert planet <Arrakis>.\n\nA stunning blend of adventure.",
+		URL:         baseURL + "dune",
+	}
+
+	text := details.alfredText()
+	if want := "Dune — Frank Herbert (1965)\n\nSet on the desert planet <Arrakis>."; !strings.HasPrefix(text["largetype"], want) {
+		t.Errorf("largetype = %q", text["largetype"])
+	}
+	if !strings.HasSuffix(text["copy"], "\n\n"+baseURL+"dune") {
+		t.Errorf("copy = %q, want the Hardcover link last", text["copy"])
+	}
+
+	item := map[string]interface{}{}
+	addDetails(item, details)
+	pagePath, _ := item["quicklookurl"].(string)
+	page, err := os.ReadFile(pagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<h1>Dune</h1>", "<p>Set on the desert planet &lt;Arrakis&gt;.</p>", "<p>A stunning blend of adventure.</p>"} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("page misses %q", want)
+		}
+	}
+
+	// an unchanged page is left alone
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(pagePath, old, old)
+	writeDetailsPage(details)
+	if info, _ := os.Stat(pagePath); !info.ModTime().Equal(old) {
+		t.Errorf("unchanged page was rewritten")
+	}
+}
+
+func TestDetailsPageLimit(t *testing.T) {
+	detailsDir = t.TempDir()
+	detailsPagesWritten = detailsPageLimit - 1
+	t.Cleanup(func() { detailsPagesWritten = 0 })
+
+	first, second := map[string]interface{}{}, map[string]interface{}{}
+	addDetails(first, bookDetails{ID: 1, Title: "Emma", URL: baseURL + "emma"})
+	addDetails(second, bookDetails{ID: 2, Title: "Persuasion", URL: baseURL + "persuasion"})
+	if first["quicklookurl"] == baseURL+"emma" {
+		t.Errorf("first result has no page")
+	}
+	if second["quicklookurl"] != baseURL+"persuasion" {
+		t.Errorf("result past the limit quicklookurl = %v, want the Hardcover page", second["quicklookurl"])
+	}
+	if second["text"] == nil {
+		t.Errorf("result past the limit lost its text")
+	}
+}
+
+func TestPruneDetailsPages(t *testing.T) {
+	detailsDir = t.TempDir()
+	for _, name := range []string{"1.html", "2.html", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(detailsDir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := pruneDetailsPages(map[int]BookInfoMap{1: {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removed %d pages, want 1", removed)
+	}
+	for name, want := range map[string]bool{"1.html": true, "2.html": false, "notes.txt": true} {
+		if _, err := os.Stat(filepath.Join(detailsDir, name)); (err == nil) != want {
+			t.Errorf("%s kept = %v, want %v", name, err == nil, want)
+		}
+	}
+}
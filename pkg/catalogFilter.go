@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Library filters of the catalog search: --new hides the books already in
+// the library (with any status, or only on a shelf), --owned keeps only
+// those. The flag stays in the search, so "Load more" keeps the mode; the
+// search cache holds the unfiltered results, shared by all modes.
+
+// libraryFilter is the library filter of a catalog search
+type libraryFilter struct {
+	Name        string
+	Aliases     []string
+	Description string
+	InLibrary   bool // books kept: in the library, or not
+}
+
+var libraryFilters = []libraryFilter{
+	{Name: "new", Description: "not in my library", InLibrary: false},
+	{Name: "owned", Aliases: []string{"library", "lib"}, Description: "in my library", InLibrary: true},
+}
+
+// extractLibraryFilter removes the filter flags from a search; the last one
+// typed wins. It runs before extractSortFlags, which rejects unknown flags.
+func extractLibraryFilter(searchString string) (string, *libraryFilter) {
+	var kept []string
+	var filter *libraryFilter
+	for _, token := range strings.Fields(searchString) {
+		if found := findLibraryFilter(token); found != nil {
+			filter = found
+			continue
+		}
+		kept = append(kept, token)
+	}
+	return strings.Join(kept, " "), filter
+}
+
+// findLibraryFilter returns the filter a --flag names, or nil
+func findLibraryFilter(token string) *libraryFilter {
+	name, isFlag := strings.CutPrefix(token, "--")
+	if !isFlag {
+		return nil
+	}
+	for i, filter := range libraryFilters {
+		if strings.EqualFold(filter.Name, name) {
+			return &libraryFilters[i]
+		}
+		for _, alias := range filter.Aliases {
+			if strings.EqualFold(alias, name) {
+				return &libraryFilters[i]
+			}
+		}
+	}
+	return nil
+}
+
+// apply returns the books the filter keeps; a nil filter keeps them all
+func (f *libraryFilter) apply(books []BookSearch, bookStatusMap map[int]BookInfoMap) []BookSearch {
+	if f == nil {
+		return books
+	}
+	kept := make([]BookSearch, 0, len(books))
+	for _, book := range books {
+		if _, inLibrary := bookStatusMap[book.ID]; inLibrary == f.InLibrary {
+			kept = append(kept, book)
+		}
+	}
+	return kept
+}
+
+// filteredOutItem stands in for a page whose books were all filtered out
+func filteredOutItem(f *libraryFilter, hidden int) map[string]interface{} {
+	return map[string]interface{}{
+		"title":    "No books " + f.Description,
+		"subtitle": pluralBooks(hidden) + " hidden by --" + f.Name,
+		"valid":    false,
+		"icon": map[string]string{
+			"path": "icons/hopeless.png",
+		},
+	}
+}
+
+// pluralBooks is "1 book", "2 books"
+func pluralBooks(n int) string {
+	if n == 1 {
+		return "1 book"
+	}
+	return fmt.Sprintf("%d books", n)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestExtractLibraryFilter(t *testing.T) {
+	search, filter := extractLibraryFilter("dune --NEW --y --page=2")
+	if search != "dune --y --page=2" || filter == nil || filter.Name != "new" {
+		t.Errorf("extractLibraryFilter = %q, %+v", search, filter)
+	}
+	if _, filter := extractLibraryFilter("dune --new --lib"); filter == nil || filter.Name != "owned" {
+		t.Errorf("last filter should win, got %+v", filter)
+	}
+	if search, filter := extractLibraryFilter("dune --mine"); search != "dune --mine" || filter != nil {
+		t.Errorf("sort flag taken as a filter: %q, %+v", search, filter)
+	}
+}
+
+func TestLibraryFilterApply(t *testing.T) {
+	books := []BookSearch{{ID: 1}, {ID: 2}, {ID: 3}}
+	library := map[int]BookInfoMap{2: {StatusID: 1}, 3: {Shelves: "favorites"}}
+
+	ids := func(books []BookSearch) []int {
+		var ids []int
+		for _, book := range books {
+			ids = append(ids, book.ID)
+		}
+		return ids
+	}
+	newBooks := findLibraryFilter("--new").apply(books, library)
+	if got := ids(newBooks); len(got) != 1 || got[0] != 1 {
+		t.Errorf("--new kept %v, want [1]", got)
+	}
+	owned := findLibraryFilter("--owned").apply(books, library)
+	if got := ids(owned); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("--owned kept %v, want [2 3]", got)
+	}
+	var none *libraryFilter
+	if got := none.apply(books, library); len(got) != 3 {
+		t.Errorf("no filter kept %d books, want 3", len(got))
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Catalog search beyond books. A search starting with a:, s:, l: or u: looks
+// for authors, series, lists or users; Enter on one of them drills down
+// (an author's books, a series in reading order, a list's books, a user's
+// public lists) by autocompleting a:#<id> into the same search.
+
+const (
+	hardcoverURL   = "https://hardcover.app/"
+	drillDownLimit = 100 // books or lists shown when drilling down
+)
+
+// catalogKind is a kind of Hardcover search document other than books
+type catalogKind struct {
+	Prefix    string // typed before the search, e.g. "a:"
+	QueryType string // query_type of the search endpoint
+	Label     string
+	Icon      string
+}
+
+var catalogKinds = []catalogKind{
+	{Prefix: "a:", QueryType: "Author", Label: "authors", Icon: "icon.png"},
+	{Prefix: "s:", QueryType: "Series", Label: "series", Icon: "icons/bookPile.png"},
+	{Prefix: "l:", QueryType: "List", Label: "lists", Icon: "icons/shelf.png"},
+	{Prefix: "u:", QueryType: "User", Label: "users", Icon: "icon.png"},
+}
+
+// findCatalogKind returns the kind with the given query type
+func findCatalogKind(queryType string) catalogKind {
+	for _, kind := range catalogKinds {
+		if kind.QueryType == queryType {
+			return kind
+		}
+	}
+	return catalogKind{}
+}
+
+// splitCatalogKind recognizes a kind prefix. drillDownID is set for a:#<id>
+// searches; rest is the search without the prefix.
+func splitCatalogKind(searchString string) (kind catalogKind, rest string, drillDownID int, found bool) {
+	for _, candidate := range catalogKinds {
+		if len(searchString) < len(candidate.Prefix) || !strings.EqualFold(searchString[:len(candidate.Prefix)], candidate.Prefix) {
+			continue
+		}
+		rest = strings.TrimSpace(searchString[len(candidate.Prefix):])
+		if strings.HasPrefix(rest, "#") {
+			idText, _, _ := strings.Cut(rest[1:], " ")
+			if id, err := strconv.Atoi(idText); err == nil && id > 0 {
+				drillDownID = id
+			}
+		}
+		return candidate, rest, drillDownID, true
+	}
+	return catalogKind{}, searchString, 0, false
+}
+
+// drillDownQuery is the search that opens an entry
+func drillDownQuery(kind catalogKind, id int, name string) string {
+	return fmt.Sprintf("%s#%d %s", kind.Prefix, id, name)
+}
+
+// catalogEntry is an author, series, list or user found in the catalog
+type catalogEntry interface {
+	alfredItem(kind catalogKind) map[string]interface{}
+}
+
+// AuthorSearch is an author document of the search endpoint
+type AuthorSearch struct {
+	ID         int
+	Name       string
+	Slug       string
+	BooksCount int
+	ImageURL   string
+	Books      []string // best-known titles
+}
+
+// SeriesSearch is a series document of the search endpoint
+type SeriesSearch struct {
+	ID                int
+	Name              string
+	Slug              string
+	Author            string
+	BooksCount        int
+	PrimaryBooksCount int
+	Books             []string
+}
+
+// ListSearch is a list document of the search endpoint, or a list of a user
+type ListSearch struct {
+	ID          int
+	Name        string
+	Slug        string
+	Description string
+	BooksCount  int
+	LikesCount  int
+	Owner       string // username
+}
+
+// UserSearch is a user document of the search endpoint
+type UserSearch struct {
+	ID             int
+	Username       string
+	Name           string
+	ImageURL       string
+	BooksCount     int
+	FollowersCount int
+}
+
+// searchDocumentID reads the id of a search document, sent as a string
+type searchDocumentID string
+
+func (id searchDocumentID) Int() int {
+	value, err := strconv.Atoi(string(id))
+	if err != nil {
+		LogF("Error converting document id %q to int: %v", id, err)
+	}
+	return value
+}
+
+// GraphQLResponseSearchDocuments is a search response with the documents
+// left raw, to be decoded by kind
+type GraphQLResponseSearchDocuments struct {
+	Data struct {
+		Search struct {
+			Results struct {
+				Found int `json:"found"`
+				Hits  []struct {
+					Document json.RawMessage `json:"document"`
+				} `json:"hits"`
+			} `json:"results"`
+		} `json:"search"`
+	} `json:"data"`
+}
+
+// extractCatalogEntries decodes the documents of a search response
+func extractCatalogEntries(kind catalogKind, responseBody []byte) ([]catalogEntry, error) {
+	var response GraphQLResponseSearchDocuments
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode %s search: %w", kind.Label, err)
+	}
+
+	var entries []catalogEntry
+	for _, hit := range response.Data.Search.Results.Hits {
+		entry, err := decodeCatalogDocument(kind, hit.Document)
+		if err != nil {
+			LogF("Skipping %s document: %v", kind.Label, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// decodeCatalogDocument decodes one search document into the type of its kind
+func decodeCatalogDocument(kind catalogKind, document json.RawMessage) (catalogEntry, error) {
+	type image struct {
+		URL string `json:"url"`
+	}
+	switch kind.QueryType {
+	case "Author":
+		var doc struct {
+			ID         searchDocumentID `json:"id"`
+			Name       string           `json:"name"`
+			Slug       string           `json:"slug"`
+			BooksCount int              `json:"books_count"`
+			Image      image            `json:"image"`
+			Books      []string         `json:"books"`
+		}
+		if err := json.Unmarshal(document, &doc); err != nil {
+			return nil, err
+		}
+		return AuthorSearch{ID: doc.ID.Int(), Name: doc.Name, Slug: doc.Slug, BooksCount: doc.BooksCount, ImageURL: doc.Image.URL, Books: doc.Books}, nil
+	case "Series":
+		var doc struct {
+			ID                searchDocumentID `json:"id"`
+			Name              string           `json:"name"`
+			Slug              string           `json:"slug"`
+			AuthorName        string           `json:"author_name"`
+			BooksCount        int              `json:"books_count"`
+			PrimaryBooksCount int              `json:"primary_books_count"`
+			Books             []string         `json:"books"`
+		}
+		if err := json.Unmarshal(document, &doc); err != nil {
+			return nil, err
+		}
+		return SeriesSearch{ID: doc.ID.Int(), Name: doc.Name, Slug: doc.Slug, Author: doc.AuthorName, BooksCount: doc.BooksCount, PrimaryBooksCount: doc.PrimaryBooksCount, Books: doc.Books}, nil
+	case "List":
+		var doc struct {
+			ID          searchDocumentID `json:"id"`
+			Name        string           `json:"name"`
+			Slug        string           `json:"slug"`
+			Description string           `json:"description"`
+			BooksCount  int              `json:"books_count"`
+			LikesCount  int              `json:"likes_count"`
+			User        struct {
+				Username string `json:"username"`
+			} `json:"user"`
+		}
+		if err := json.Unmarshal(document, &doc); err != nil {
+			return nil, err
+		}
+		return ListSearch{ID: doc.ID.Int(), Name: doc.Name, Slug: doc.Slug, Description: doc.Description, BooksCount: doc.BooksCount, LikesCount: doc.LikesCount, Owner: doc.User.Username}, nil
+	case "User":
+		var doc struct {
+			ID             searchDocumentID `json:"id"`
+			Username       string           `json:"username"`
+			Name           string           `json:"name"`
+			Image          image            `json:"image"`
+			BooksCount     int              `json:"books_count"`
+			FollowersCount int              `json:"followers_count"`
+		}
+		if err := json.Unmarshal(document, &doc); err != nil {
+			return nil, err
+		}
+		return UserSearch{ID: doc.ID.Int(), Username: doc.Username, Name: doc.Name, ImageURL: doc.Image.URL, BooksCount: doc.BooksCount, FollowersCount: doc.FollowersCount}, nil
+	}
+	return nil, fmt.Errorf("unknown search kind %q", kind.QueryType)
+}
+
+// drillDownItem is the Alfred item of an entry: Enter (or Tab) opens it in
+// the same search, Quick Look shows its Hardcover page
+func drillDownItem(kind catalogKind, id int, title, subtitle, pageURL, imageURL string) map[string]interface{} {
+	icon := kind.Icon
+	if imageURL != "" {
+		icon = coverPath(imageURL)
+	}
+	return map[string]interface{}{
+		"title":        title,
+		"subtitle":     subtitle,
+		"valid":        false,
+		"autocomplete": drillDownQuery(kind, id, title),
+		"quicklookurl": pageURL,
+		"icon": map[string]string{
+			"path": icon,
+		},
+		"mods": map[string]interface{}{
+			"cmd": map[string]interface{}{
+				"valid":    true,
+				"arg":      pageURL,
+				"subtitle": "Open on Hardcover",
+			},
+		},
+	}
+}
+
+func (a AuthorSearch) alfredItem(kind catalogKind) map[string]interface{} {
+	subtitle := fmt.Sprintf("%d books", a.BooksCount)
+	if len(a.Books) > 0 {
+		subtitle += ": " + strings.Join(firstN(a.Books, 3), ", ")
+	}
+	return drillDownItem(kind, a.ID, a.Name, subtitle, hardcoverURL+"authors/"+a.Slug, a.ImageURL)
+}
+
+func (s SeriesSearch) alfredItem(kind catalogKind) map[string]interface{} {
+	count := s.PrimaryBooksCount
+	if count == 0 {
+		count = s.BooksCount
+	}
+	subtitle := fmt.Sprintf("%d books", count)
+	if s.Author != "" {
+		subtitle = s.Author + ", " + subtitle
+	}
+	if len(s.Books) > 0 {
+		subtitle += ": " + strings.Join(firstN(s.Books, 3), ", ")
+	}
+	return drillDownItem(kind, s.ID, s.Name, subtitle, hardcoverURL+"series/"+s.Slug, "")
+}
+
+func (l ListSearch) alfredItem(kind catalogKind) map[string]interface{} {
+	subtitle := fmt.Sprintf("%d books", l.BooksCount)
+	if l.Owner != "" {
+		subtitle = "by @" + l.Owner + ", " + subtitle
+	}
+	if l.LikesCount > 0 {
+		subtitle += fmt.Sprintf(", %d♥", l.LikesCount)
+	}
+	if l.Description != "" {
+		subtitle += " · " + l.Description
+	}
+	return drillDownItem(kind, l.ID, l.Name, subtitle, hardcoverURL+"@"+l.Owner+"/lists/"+l.Slug, "")
+}
+
+func (u UserSearch) alfredItem(kind catalogKind) map[string]interface{} {
+	title := "@" + u.Username
+	if u.Name != "" && u.Name != u.Username {
+		title = u.Name + " (@" + u.Username + ")"
+	}
+	subtitle := fmt.Sprintf("%d books, %d followers", u.BooksCount, u.FollowersCount)
+	return drillDownItem(kind, u.ID, title, subtitle, hardcoverURL+"@"+u.Username, u.ImageURL)
+}
+
+func firstN(values []string, n int) []string {
+	if len(values) > n {
+		return values[:n]
+	}
+	return values
+}
+
+// searchCatalogKind runs a search for authors, series, lists or users
+func searchCatalogKind(kind catalogKind, searchString string) ([]catalogEntry, error) {
+	body, err := searchRemote(kind.QueryType, searchString, 1)
+	if err != nil {
+		return nil, err
+	}
+	return extractCatalogEntries(kind, body)
+}
+
+// catalogBooksResponse decodes drill-down responses; each query fills one field
+type catalogBooksResponse struct {
+	Data struct {
+		Books      []Book `json:"books"`
+		BookSeries []struct {
+			Position *float64 `json:"position"`
+			Book     Book     `json:"book"`
+		} `json:"book_series"`
+		ListBooks []struct {
+			Book Book `json:"book"`
+		} `json:"list_books"`
+		Lists []struct {
+			ID          int    `json:"id"`
+			Name        string `json:"name"`
+			Slug        string `json:"slug"`
+			Description string `json:"description"`
+			BooksCount  int    `json:"books_count"`
+			User        struct {
+				Username string `json:"username"`
+			} `json:"user"`
+		} `json:"lists"`
+	} `json:"data"`
+}
+
+func fetchCatalogDrillDown(query string, variables graphQLVars) (catalogBooksResponse, error) {
+	var response catalogBooksResponse
+	body, err := interrogateAPI(newGraphQLRequest(query, variables))
+	if err != nil {
+		return response, err
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return response, fmt.Errorf("failed to decode drill-down: %w", err)
+	}
+	return response, nil
+}
+
+// drillDownBooks returns the books of an author, a series (one book per
+// position, in reading order) or a list
+func drillDownBooks(kind catalogKind, id int) ([]BookSearch, error) {
+	var books []BookSearch
+	switch kind.QueryType {
+	case "Author":
+		response, err := fetchCatalogDrillDown(authorBooksQuery, graphQLVars{"authorID": id, "limit": drillDownLimit})
+		if err != nil {
+			return nil, err
+		}
+		for _, book := range response.Data.Books {
+			books = append(books, catalogBook(book))
+		}
+	case "Series":
+		response, err := fetchCatalogDrillDown(seriesBooksQuery, graphQLVars{"seriesID": id})
+		if err != nil {
+			return nil, err
+		}
+		// editions of the same volume share a position; the most read comes first
+		seen := make(map[float64]bool)
+		for _, entry := range response.Data.BookSeries {
+			book := catalogBook(entry.Book)
+			if entry.Position != nil {
+				if seen[*entry.Position] {
+					continue
+				}
+				seen[*entry.Position] = true
+				book.SeriesPosition = strconv.FormatFloat(*entry.Position, 'f', -1, 64)
+			}
+			books = append(books, book)
+		}
+	case "List":
+		response, err := fetchCatalogDrillDown(listBooksQuery, graphQLVars{"listID": id, "limit": drillDownLimit})
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range response.Data.ListBooks {
+			books = append(books, catalogBook(entry.Book))
+		}
+	default:
+		return nil, fmt.Errorf("%s have no books", kind.Label)
+	}
+	for i := range books {
+		books[i].Found = len(books)
+	}
+	return books, nil
+}
+
+// drillDownLists returns the public lists of a user
+func drillDownLists(id int) ([]catalogEntry, error) {
+	response, err := fetchCatalogDrillDown(userListsQuery, graphQLVars{"userID": id})
+	if err != nil {
+		return nil, err
+	}
+	var entries []catalogEntry
+	for _, list := range response.Data.Lists {
+		entries = append(entries, ListSearch{
+			ID:          list.ID,
+			Name:        list.Name,
+			Slug:        list.Slug,
+			Description: list.Description,
+			BooksCount:  list.BooksCount,
+			Owner:       list.User.Username,
+		})
+	}
+	return entries, nil
+}
+
+// serveCatalogEntries shows authors, series, lists or users
+func serveCatalogEntries(kind catalogKind, searchString string, entries []catalogEntry) {
+	var imageURLs []string
+	for _, entry := range entries {
+		switch entry := entry.(type) {
+		case AuthorSearch:
+			imageURLs = append(imageURLs, entry.ImageURL)
+		case UserSearch:
+			imageURLs = append(imageURLs, entry.ImageURL)
+		}
+	}
+	covers.Fetch(imageURLs)
+
+	items := []map[string]interface{}{}
+	for _, entry := range entries {
+		itemKind := kind
+		if _, isList := entry.(ListSearch); isList {
+			// a user's lists open like lists
+			itemKind = findCatalogKind("List")
+		}
+		items = append(items, entry.alfredItem(itemKind))
+	}
+	if len(items) == 0 {
+		title := fmt.Sprintf("No %s found", kind.Label)
+		if searchString == "" {
+			title = fmt.Sprintf("Type to search %s", kind.Label)
+		}
+		items = append(items, map[string]interface{}{
+			"title":    title,
+			"subtitle": searchString,
+			"valid":    false,
+			"icon": map[string]string{
+				"path": "icons/hopeless.png",
+			},
+		})
+	}
+
+	jsonData, err := json.MarshalIndent(map[string]interface{}{"items": items}, "", "  ")
+	if err != nil {
+		LogF("Error encoding JSON: %v", err)
+		return
+	}
+	fmt.Println(string(jsonData))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCatalogKind(t *testing.T) {
+	tests := []struct {
+		search      string
+		queryType   string
+		rest        string
+		drillDownID int
+	}{
+		{"dune", "", "dune", 0},
+		{"a:herbert", "Author", "herbert", 0},
+		{"S: the expanse", "Series", "the expanse", 0},
+		{"l:", "List", "", 0},
+		{"u:#42 Ada", "User", "#42 Ada", 42},
+		{"s:#7", "Series", "#7", 7},
+		{"a:#abc", "Author", "#abc", 0},
+	}
+	for _, test := range tests {
+		kind, rest, id, found := splitCatalogKind(test.search)
+		if found != (test.queryType != "") || kind.QueryType != test.queryType || rest != test.rest || id != test.drillDownID {
+			t.Errorf("splitCatalogKind(%q) = %q, %q, %d, %v", test.search, kind.QueryType, rest, id, found)
+		}
+	}
+
+	kind := findCatalogKind("Author")
+	_, _, id, _ := splitCatalogKind(drillDownQuery(kind, 123, "Frank Herbert"))
+	if id != 123 {
+		t.Errorf("drill-down query does not round-trip, got id %d", id)
+	}
+}
+
+func TestExtractCatalogEntries(t *testing.T) {
+	body := []byte(`{"data": {"search": {"results": {"found": 2, "hits": [
+		{"document": {"id": "204214", "name": "Frank Herbert", "slug": "frank-herbert", "books_count": 268,
+			"image": {"url": "https://assets.hardcover.app/herbert.jpg"}, "books": ["Dune", "Dune Messiah"]}},
+		{"document": {"id": "oops", "name": "Brian Herbert", "slug": "brian-herbert"}}
+	]}}}}`)
+	entries, err := extractCatalogEntries(findCatalogKind("Author"), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []catalogEntry{
+		AuthorSearch{ID: 204214, Name: "Frank Herbert", Slug: "frank-herbert", BooksCount: 268,
+			ImageURL: "https://assets.hardcover.app/herbert.jpg", Books: []string{"Dune", "Dune Messiah"}},
+		AuthorSearch{Name: "Brian Herbert", Slug: "brian-herbert"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %+v, want %+v", entries, want)
+	}
+
+	body = []byte(`{"data": {"search": {"results": {"found": 1, "hits": [
+		{"document": {"id": "9", "name": "Best of 2024", "slug": "best-of-2024", "books_count": 12,
+			"likes_count": 3, "user": {"username": "ada"}}}
+	]}}}}`)
+	entries, err = extractCatalogEntries(findCatalogKind("List"), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := entries[0].alfredItem(findCatalogKind("List"))
+	if item["autocomplete"] != "l:#9 Best of 2024" || item["quicklookurl"] != "https://hardcover.app/@ada/lists/best-of-2024" {
+		t.Errorf("list item = %v", item)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Exact catalog lookups. A pasted ISBN-10, ISBN-13 (hyphens and spaces
+// allowed) or hardcover.app/books/<slug> URL is resolved directly instead of
+// going through the full-text search, and the book found is shown first.
+
+// catalogIdentifier is an ISBN or a book slug recognized in a search
+type catalogIdentifier struct {
+	Kind  string // "isbn" or "slug"
+	Value string // normalized ISBN (10 or 13 characters) or slug
+}
+
+// description is shown in the subtitle of the exact match
+func (id catalogIdentifier) description() string {
+	if id.Kind == "slug" {
+		return "Hardcover URL"
+	}
+	return "ISBN " + id.Value
+}
+
+var hardcoverBookURL = regexp.MustCompile(`(?i)^(?:https?://)?(?:www\.)?hardcover\.app/books/([a-z0-9][a-z0-9_-]*)(?:[/?#].*)?$`)
+
+// detectCatalogIdentifier recognizes a search made of a single ISBN with a
+// valid checksum, or of a Hardcover book URL
+func detectCatalogIdentifier(search string) (catalogIdentifier, bool) {
+	search = strings.TrimSpace(search)
+	if match := hardcoverBookURL.FindStringSubmatch(search); match != nil {
+		return catalogIdentifier{Kind: "slug", Value: strings.ToLower(match[1])}, true
+	}
+
+	isbn := normalizeISBN(strings.TrimPrefix(strings.ToUpper(search), "ISBN"))
+	if isbn == "" || !validISBN(isbn) {
+		return catalogIdentifier{}, false
+	}
+	return catalogIdentifier{Kind: "isbn", Value: isbn}, true
+}
+
+// validISBN checks the check digit of a normalized ISBN-10 or ISBN-13
+func validISBN(isbn string) bool {
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, r := range isbn {
+			digit := int(r - '0')
+			if r == 'X' {
+				digit = 10
+			}
+			sum += (10 - i) * digit
+		}
+		return sum%11 == 0
+	case 13:
+		if !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") {
+			return false
+		}
+		return isbn13CheckDigit(isbn[:12]) == isbn[12]
+	}
+	return false
+}
+
+// isbn13CheckDigit computes the check digit of the first 12 digits
+func isbn13CheckDigit(digits string) byte {
+	sum := 0
+	for i, r := range digits {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(r-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// isbnVariants returns the ISBN-13 and ISBN-10 forms of a valid ISBN; books
+// from before 2007 are often only known by one of them
+func isbnVariants(isbn string) []string {
+	switch len(isbn) {
+	case 10:
+		prefix := "978" + isbn[:9]
+		return []string{prefix + string(isbn13CheckDigit(prefix)), isbn}
+	case 13:
+		if !strings.HasPrefix(isbn, "978") {
+			return []string{isbn}
+		}
+		sum := 0
+		for i, r := range isbn[3:12] {
+			sum += (10 - i) * int(r-'0')
+		}
+		check := (11 - sum%11) % 11
+		checkDigit := string(rune('0' + check))
+		if check == 10 {
+			checkDigit = "X"
+		}
+		return []string{isbn, isbn[3:12] + checkDigit}
+	}
+	return nil
+}
+
+// catalogBook converts a book of the API into a catalog search result
+func catalogBook(book Book) BookSearch {
+	var authors []string
+	seen := make(map[string]bool)
+	for _, contributor := range book.CachedContributors {
+		if name := contributor.Author.Name; name != "" && !seen[name] {
+			seen[name] = true
+			authors = append(authors, name)
+		}
+	}
+	return BookSearch{
+		Found:       1,
+		Authors:     strings.Join(authors, ", "),
+		Title:       book.Title,
+		Description: book.Description,
+		ImageURL:    book.CachedImage.URL,
+		Rating:      book.Rating,
+		Raters:      book.RatingsCount,
+		ID:          book.ID,
+		ReleaseYear: book.ReleaseYear,
+		Slug:        book.Slug,
+	}
+}
+
+// lookupCatalogIdentifier fetches the book an identifier points to; found
+// is false when the catalog does not know it
+func lookupCatalogIdentifier(id catalogIdentifier) (BookSearch, bool, error) {
+	var books []Book
+	if id.Kind == "slug" {
+		body, err := interrogateAPI(newGraphQLRequest(bookBySlugQuery, graphQLVars{"slug": id.Value}))
+		if err != nil {
+			return BookSearch{}, false, err
+		}
+		var response APIBookLookup
+		if err := json.Unmarshal(body, &response); err != nil {
+			return BookSearch{}, false, fmt.Errorf("failed to decode book lookup: %w", err)
+		}
+		books = response.Data.Books
+	} else {
+		body, err := interrogateAPI(newGraphQLRequest(editionByISBNQuery, graphQLVars{"isbns": isbnVariants(id.Value)}))
+		if err != nil {
+			return BookSearch{}, false, err
+		}
+		var response APIEditionLookup
+		if err := json.Unmarshal(body, &response); err != nil {
+			return BookSearch{}, false, fmt.Errorf("failed to decode edition lookup: %w", err)
+		}
+		for _, edition := range response.Data.Editions {
+			books = append(books, edition.Book)
+		}
+	}
+
+	if len(books) == 0 || books[0].ID == 0 {
+		return BookSearch{}, false, nil
+	}
+	book := catalogBook(books[0])
+	book.MatchedBy = id.description()
+	return book, true, nil
+}
+
+// searchCatalog runs a catalog search; an ISBN or a book URL is looked up
+// first, and the book found leads the regular results
+func searchCatalog(searchString string) ([]BookSearch, error) {
+	id, isIdentifier := detectCatalogIdentifier(searchString)
+	if !isIdentifier {
+		return queryRemoteDatabase(searchString, 1)
+	}
+
+	exact, found, err := lookupCatalogIdentifier(id)
+	if err != nil {
+		return nil, err
+	}
+	LogF("Exact lookup of %s: found=%v", id.description(), found)
+
+	// the search endpoint knows ISBNs; for a URL, search the slug words
+	textSearch := id.Value
+	if id.Kind == "slug" {
+		textSearch = strings.ReplaceAll(id.Value, "-", " ")
+	}
+	books, err := queryRemoteDatabase(textSearch, 1)
+	if err != nil {
+		if found {
+			return []BookSearch{exact}, nil
+		}
+		return nil, err
+	}
+	if !found {
+		return books, nil
+	}
+
+	results := []BookSearch{exact}
+	for _, book := range books {
+		if book.ID != exact.ID {
+			results = append(results, book)
+		}
+	}
+	return results, nil
+}
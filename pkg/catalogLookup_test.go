@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectCatalogIdentifier(t *testing.T) {
+	tests := []struct {
+		search string
+		want   catalogIdentifier
+		ok     bool
+	}{
+		{"9780441172719", catalogIdentifier{"isbn", "9780441172719"}, true},
+		{" 978-0-441-17271-9 ", catalogIdentifier{"isbn", "9780441172719"}, true},
+		{"ISBN 0-441-17271-7", catalogIdentifier{"isbn", "0441172717"}, true},
+		{"080442957x", catalogIdentifier{"isbn", "080442957X"}, true},
+		{"https://hardcover.app/books/dune", catalogIdentifier{"slug", "dune"}, true},
+		{"hardcover.app/books/the-left-hand-of-darkness/editions?x=1", catalogIdentifier{"slug", "the-left-hand-of-darkness"}, true},
+		{"www.Hardcover.app/books/Dune/", catalogIdentifier{"slug", "dune"}, true},
+		// wrong check digits
+		{"9780441172718", catalogIdentifier{}, false},
+		{"0441172718", catalogIdentifier{}, false},
+		// not 978/979
+		{"1234567890128", catalogIdentifier{}, false},
+		{"dune", catalogIdentifier{}, false},
+		{"https://hardcover.app/authors/frank-herbert", catalogIdentifier{}, false},
+		{"dune hardcover.app/books/dune", catalogIdentifier{}, false},
+	}
+	for _, test := range tests {
+		got, ok := detectCatalogIdentifier(test.search)
+		if ok != test.ok || got != test.want {
+			t.Errorf("detectCatalogIdentifier(%q) = %+v, %v; want %+v, %v", test.search, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestISBNVariants(t *testing.T) {
+	tests := map[string][]string{
+		"0441172717":    {"9780441172719", "0441172717"},
+		"9780441172719": {"9780441172719", "0441172717"},
+		"080442957X":    {"9780804429573", "080442957X"},
+		"9780804429573": {"9780804429573", "080442957X"},
+		"9791032305690": {"9791032305690"},
+	}
+	for isbn, want := range tests {
+		got := isbnVariants(isbn)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("isbnVariants(%q) = %v, want %v", isbn, got, want)
+		}
+		for _, variant := range got {
+			if !validISBN(variant) {
+				t.Errorf("variant %q of %q has a wrong check digit", variant, isbn)
+			}
+		}
+	}
+}
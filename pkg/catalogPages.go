@@ -0,0 +1,88 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Catalog search pagination. The "Load more" item autocompletes the search
+// with --page=N; pages already fetched come from the search cache and only
+// the missing ones are requested.
+
+var pageFlag = regexp.MustCompile(`^--page=(\d+)$`)
+
+// extractPageFlag removes --page=N from a search and returns N (1 without
+// the flag)
+func extractPageFlag(searchString string) (string, int) {
+	pages := 1
+	var kept []string
+	for _, token := range strings.Fields(searchString) {
+		if match := pageFlag.FindStringSubmatch(token); match != nil {
+			if n, err := strconv.Atoi(match[1]); err == nil && n > 0 {
+				pages = n
+			}
+			continue
+		}
+		kept = append(kept, token)
+	}
+	return strings.Join(kept, " "), pages
+}
+
+// withPageFlag is the search showing the given number of pages
+func withPageFlag(searchString string, pages int) string {
+	searchString, _ = extractPageFlag(searchString)
+	return searchString + " --page=" + strconv.Itoa(pages)
+}
+
+// fetchCatalogPages fetches pages first..last of a book search and appends
+// the books not already in books
+func fetchCatalogPages(books []BookSearch, searchString string, first, last int) ([]BookSearch, error) {
+	seen := make(map[int]bool)
+	for _, book := range books {
+		seen[book.ID] = true
+	}
+	for page := first; page <= last; page++ {
+		pageBooks, err := queryRemoteDatabase(searchString, page)
+		if err != nil {
+			return books, err
+		}
+		if len(pageBooks) == 0 {
+			break
+		}
+		for _, book := range pageBooks {
+			if !seen[book.ID] {
+				seen[book.ID] = true
+				books = append(books, book)
+			}
+		}
+	}
+	return books, nil
+}
+
+// catalogTotal is the number of books the server found for a search
+func catalogTotal(books []BookSearch) int {
+	total := len(books)
+	for _, book := range books {
+		if book.Found > total {
+			total = book.Found
+		}
+	}
+	return total
+}
+
+// loadMoreItem offers the next page of a search
+func loadMoreItem(searchString string, shown, total, pages int) map[string]interface{} {
+	return map[string]interface{}{
+		"title":        "Load more results…",
+		"subtitle":     message.NewPrinter(language.English).Sprintf("Showing %d of %d", shown, total),
+		"valid":        false,
+		"autocomplete": withPageFlag(searchString, pages+1),
+		"icon": map[string]string{
+			"path": "icons/bookPile.png",
+		},
+	}
+}
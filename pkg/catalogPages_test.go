@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestExtractPageFlag(t *testing.T) {
+	search, pages := extractPageFlag("dune --y --page=3")
+	if search != "dune --y" || pages != 3 {
+		t.Errorf("extractPageFlag = %q, %d; want %q, 3", search, pages, "dune --y")
+	}
+	if _, pages := extractPageFlag("dune --page=0"); pages != 1 {
+		t.Errorf("--page=0 gives %d pages, want 1", pages)
+	}
+	if got := withPageFlag("dune --page=2 --y", 3); got != "dune --y --page=3" {
+		t.Errorf("withPageFlag = %q", got)
+	}
+}
+
+func TestCatalogTotal(t *testing.T) {
+	books := []BookSearch{{ID: 1, Found: 345}, {ID: 2, Found: 345}}
+	if got := catalogTotal(books); got != 345 {
+		t.Errorf("catalogTotal = %d, want the server count 345", got)
+	}
+	if got := catalogTotal([]BookSearch{{ID: 1}, {ID: 2}}); got != 2 {
+		t.Errorf("catalogTotal without count = %d, want 2", got)
+	}
+}
@@ -14,20 +14,26 @@ const apiRoot = "https://api.hardcover.app/v1/graphql"
 const baseURL = "https://hardcover.app/books/"
 const listURL = "https://hardcover.app/@giovanni/lists/"
 
-// Get the system temporary directory
-var tempDir = os.TempDir()
-
 // Declare package-level variables
 var (
-	numberResults int
-	databasePath  string
-	dataFolder    string
-	authToken     string
-	coverDir      string
-	whereClauses  []string
-	userID        int
-	username      string
-	lastUpdated   string
+	numberResults   int
+	libraryPageSize int
+	shelfPageSize   int
+	// requests per minute shared by all invocations (see ops_rateLimit.go)
+	apiBudgetPerMinute int
+	coverCacheMB       int
+	// catalog search cache (see searchCache.go)
+	searchCacheTTL     time.Duration
+	searchCacheEntries int
+	searchCachePath    string
+	databasePath       string
+	dataFolder         string
+	authToken          string
+	coverDir           string
+	detailsDir         string
+	userID             int
+	username           string
+	lastUpdated        string
 )
 
 func parseUserID(APIresponse []byte) {
@@ -69,11 +75,7 @@ func parseUserID(APIresponse []byte) {
 func fetchUserIDfile() {
 	userIDFile := filepath.Join(dataFolder, "userID")
 	// File doesn't exist, fetch userID from the server
-	APIresponse, err := interrogateAPI(`query { me {
-		id
-		username
-		updated_at
-		 } }`)
+	APIresponse, err := interrogateAPI(newGraphQLRequest(meQuery, nil))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to fetch userID: %v\n", err)
 		return
@@ -141,11 +143,20 @@ func isBeyondToday() (bool, error) {
 		if err != nil {
 			return false, fmt.Errorf("failed to parse lastUpdatedRemote timestamp: %w", err)
 		}
-		if lastUpdatedRemote.After(lastUpdatedLocal) {
-
-			LogF("Database is outdated, refreshing")
-			createLibraryDatabase()
-			os.Exit(0)
+		// a migration may be waiting for the sync to fill its new columns
+		if lastUpdatedRemote.After(lastUpdatedLocal) || fullSyncPending() {
+
+			LogF("Database is outdated, syncing changes")
+			_, err := syncLibraryDatabase(false)
+			if err == errNoSyncState {
+				// nothing to sync from: rebuild everything
+				createLibraryDatabase()
+				os.Exit(0)
+			}
+			if err != nil {
+				// keep serving the local data, the next check will retry
+				LogF("Database sync failed: %v", err)
+			}
 		} else {
 			LogF("Database is up to date")
 		}
@@ -162,6 +173,44 @@ func init() {
 		numberResults = 9
 	}
 
+	// Get page sizes used when fetching the library, default if invalid
+	libraryPageSize, err = strconv.Atoi(os.Getenv("LIBRARY_PAGE_SIZE"))
+	if err != nil || libraryPageSize <= 0 {
+		libraryPageSize = 100
+	}
+	shelfPageSize, err = strconv.Atoi(os.Getenv("SHELF_PAGE_SIZE"))
+	if err != nil || shelfPageSize <= 0 {
+		shelfPageSize = 200
+	}
+
+	// Get API_BUDGET (requests per minute) from environment, default if invalid
+	apiBudgetPerMinute, err = strconv.Atoi(os.Getenv("API_BUDGET"))
+	if err != nil || apiBudgetPerMinute <= 0 {
+		apiBudgetPerMinute = defaultAPIBudget
+	}
+
+	// Get COVER_CACHE_MB (size cap of the cover cache) from environment
+	coverCacheMB, err = strconv.Atoi(os.Getenv("COVER_CACHE_MB"))
+	if err != nil || coverCacheMB <= 0 {
+		coverCacheMB = defaultCoverCacheMB
+	}
+
+	// Get SEARCH_CACHE_HOURS and SEARCH_CACHE_ENTRIES from environment
+	searchCacheHours, err := strconv.Atoi(os.Getenv("SEARCH_CACHE_HOURS"))
+	if err != nil || searchCacheHours < 0 {
+		searchCacheHours = defaultSearchCacheHours
+	}
+	searchCacheTTL = time.Duration(searchCacheHours) * time.Hour
+	searchCacheEntries, err = strconv.Atoi(os.Getenv("SEARCH_CACHE_ENTRIES"))
+	if err != nil || searchCacheEntries <= 0 {
+		searchCacheEntries = defaultSearchCacheEntries
+	}
+
+	// Get COVER_DOWNLOADS (parallel cover downloads) from environment
+	if coverDownloads, err := strconv.Atoi(os.Getenv("COVER_DOWNLOADS")); err == nil && coverDownloads > 0 {
+		covers = newCoverCache(coverDownloads)
+	}
+
 	// Get API token
 	authToken = os.Getenv("HARDCOVER_API_TOKEN")
 	if authToken == "" {
@@ -177,6 +226,7 @@ func init() {
 	}
 
 	databasePath = filepath.Join(dataFolder, "books.db")
+	searchCachePath = filepath.Join(dataFolder, "searchCache.db")
 
 	// Set and create cover directory
 	coverDir = filepath.Join(dataFolder, "covers")
@@ -185,6 +235,13 @@ func init() {
 		return
 	}
 
+	// Quick Look pages of the books shown (see bookDetails.go)
+	detailsDir = filepath.Join(dataFolder, "details")
+	if err = os.MkdirAll(detailsDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create details directory: %v\n", err)
+		return
+	}
+
 	// Check if the user ID file exists,if not create one
 	checkUserIDFile()
 
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cover cache: every cover shown by the workflow, for the library and for
+// catalog searches, lives in coverDir under the file name of its URL.
+// Downloads run on a bounded pool of workers; a file already on disk or
+// already being downloaded is never fetched twice.
+
+const (
+	defaultCoverDownloads = 8
+	coverTimeout          = 20 * time.Second
+	imageSniffLength      = 512 // bytes looked at to recognize an image
+)
+
+var coverClient = &http.Client{Timeout: coverTimeout}
+
+// coverCache queues cover downloads and lets callers wait for them
+type coverCache struct {
+	slots    chan struct{}   // one token per running download
+	mu       sync.Mutex      // guards inflight
+	inflight map[string]bool // file names queued or downloading
+	wg       sync.WaitGroup
+}
+
+// covers is shared by the rebuild, the sync and catalog search
+var covers = newCoverCache(defaultCoverDownloads)
+
+func newCoverCache(workers int) *coverCache {
+	if workers <= 0 {
+		workers = defaultCoverDownloads
+	}
+	return &coverCache{
+		slots:    make(chan struct{}, workers),
+		inflight: make(map[string]bool),
+	}
+}
+
+// coverFileName is the name a cover is stored under, empty for no cover
+func coverFileName(url string) string {
+	if url == "" {
+		return ""
+	}
+	// Remove query parameters if present
+	fileName := path.Base(strings.SplitN(url, "?", 2)[0])
+	if fileName == "." || fileName == "/" {
+		return ""
+	}
+	return fileName
+}
+
+// coverPath is where a cover is (or will be) stored
+func coverPath(url string) string {
+	return filepath.Join(coverDir, coverFileName(url))
+}
+
+// Queue schedules a download of the cover unless it is cached or already queued
+func (c *coverCache) Queue(url string) {
+	fileName := coverFileName(url)
+	if fileName == "" {
+		return
+	}
+
+	c.mu.Lock()
+	if c.inflight[fileName] {
+		c.mu.Unlock()
+		return
+	}
+	c.inflight[fileName] = true
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.inflight, fileName)
+			c.mu.Unlock()
+		}()
+
+		c.slots <- struct{}{}        // Acquire a slot
+		defer func() { <-c.slots }() // Release slot
+
+		if err := downloadCover(url, filepath.Join(coverDir, fileName)); err != nil {
+			LogF("%v", err)
+		}
+	}()
+}
+
+// Fetch downloads the given covers and returns when all of them are done
+func (c *coverCache) Fetch(urls []string) {
+	for _, url := range urls {
+		c.Queue(url)
+	}
+	c.Wait()
+}
+
+// Wait blocks until every queued download has finished
+func (c *coverCache) Wait() {
+	c.wg.Wait()
+}
+
+// downloadCover saves url to filePath unless the file exists. The image is
+// written to a temporary file first and renamed into place, so an interrupted
+// download never leaves a truncated cover behind.
+func downloadCover(url, filePath string) error {
+	// Check if the file already exists
+	if _, err := os.Stat(filePath); err == nil {
+		return nil
+	}
+
+	resp, err := coverClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	// Check if the HTTP response status is OK
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: status code %d", url, resp.StatusCode)
+	}
+
+	// refuse error pages served with a 200
+	head := make([]byte, imageSniffLength)
+	n, err := io.ReadFull(resp.Body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	head = head[:n]
+	if !isImageHeader(head) {
+		return fmt.Errorf("failed to download %s: not an image (%s)", url, http.DetectContentType(head))
+	}
+
+	// the temporary file sits next to the cover, so the rename stays atomic
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.part")
+	if err != nil {
+		return fmt.Errorf("failed to create file for %s: %w", url, err)
+	}
+	tempPath := file.Name()
+
+	_, err = file.Write(head)
+	if err == nil {
+		_, err = io.Copy(file, resp.Body)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to save file for %s: %w", url, err)
+	}
+
+	if err := os.Rename(tempPath, filePath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to store cover %s: %w", filePath, err)
+	}
+	LogF("Image saved: %s", filePath)
+	return nil
+}
+
+// isImageHeader reports whether the first bytes of a file are an image
+func isImageHeader(head []byte) bool {
+	return len(head) > 0 && strings.HasPrefix(http.DetectContentType(head), "image/")
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// accessTime returns when a file was last read, falling back to its
+// modification time
+func accessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atimespec.Sec, stat.Atimespec.Nsec)
+	}
+	return info.ModTime()
+}
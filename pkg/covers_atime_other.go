@@ -0,0 +1,14 @@
+//go:build !darwin && !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime returns the modification time where access times are not
+// available
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cover maintenance (-covers): drops covers no book refers to, replaces
+// corrupt ones and keeps coverDir under coverCacheLimit, evicting the least
+// recently used files first. It also prunes the Quick Look pages (see
+// bookDetails.go).
+
+const (
+	defaultCoverCacheMB = 500
+	// a .part file this old belongs to a download that will never finish
+	stalePartialAge = time.Hour
+)
+
+// coverReport sums up a maintenance run
+type coverReport struct {
+	Orphans      int
+	OrphanBytes  int64
+	Broken       int
+	Refetched    int
+	Evicted      int
+	EvictedBytes int64
+	Cleared      int // evicted covers of library books
+	Files        int
+	TotalBytes   int64
+}
+
+// coverFile is a cover on disk
+type coverFile struct {
+	name       string
+	size       int64
+	accessedAt time.Time
+}
+
+// referencedCovers maps the cover files used by the library to their URL
+func referencedCovers() (map[string]string, error) {
+	db, err := openLibraryDatabase(databasePath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT cover_file, image_url FROM books WHERE IFNULL(cover_file, '') != ''`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cover files: %w", err)
+	}
+	defer rows.Close()
+
+	referenced := make(map[string]string)
+	for rows.Next() {
+		var fileName, imageURL string
+		if err := rows.Scan(&fileName, &imageURL); err != nil {
+			return nil, fmt.Errorf("failed to scan cover file: %w", err)
+		}
+		referenced[fileName] = imageURL
+	}
+	return referenced, rows.Err()
+}
+
+// isValidCover reports whether the file starts like an image
+func isValidCover(filePath string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	head := make([]byte, imageSniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+	return isImageHeader(head[:n])
+}
+
+// maintainCovers prunes, repairs and caps the cover cache
+func maintainCovers(limitBytes int64) (coverReport, error) {
+	var report coverReport
+
+	referenced, err := referencedCovers()
+	if err != nil {
+		return report, err
+	}
+
+	entries, err := os.ReadDir(coverDir)
+	if err != nil {
+		return report, fmt.Errorf("failed to read covers directory: %w", err)
+	}
+
+	var refetch []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		filePath := filepath.Join(coverDir, name)
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		// leftovers of interrupted downloads
+		if strings.HasSuffix(name, ".part") {
+			if time.Since(info.ModTime()) > stalePartialAge {
+				os.Remove(filePath)
+			}
+			continue
+		}
+
+		imageURL, used := referenced[name]
+		if !used {
+			if err := os.Remove(filePath); err == nil {
+				report.Orphans++
+				report.OrphanBytes += info.Size()
+			}
+			continue
+		}
+
+		if !isValidCover(filePath) {
+			LogF("Corrupt cover %s, downloading it again", name)
+			if err := os.Remove(filePath); err == nil {
+				report.Broken++
+				refetch = append(refetch, imageURL)
+			}
+		}
+	}
+
+	covers.Fetch(refetch)
+	for _, imageURL := range refetch {
+		if _, err := os.Stat(coverPath(imageURL)); err == nil {
+			report.Refetched++
+		}
+	}
+
+	files, err := listCoverFiles()
+	if err != nil {
+		return report, err
+	}
+	for _, file := range files {
+		report.TotalBytes += file.size
+	}
+	report.Files = len(files)
+
+	// evict the least recently used covers until the cache fits, those of
+	// catalog results (downloaded since the pruning) first
+	if limitBytes > 0 && report.TotalBytes > limitBytes {
+		sort.Slice(files, func(i, j int) bool {
+			_, iUsed := referenced[files[i].name]
+			_, jUsed := referenced[files[j].name]
+			if iUsed != jUsed {
+				return jUsed
+			}
+			return files[i].accessedAt.Before(files[j].accessedAt)
+		})
+		var cleared []string
+		for _, file := range files {
+			if report.TotalBytes <= limitBytes {
+				break
+			}
+			if err := os.Remove(filepath.Join(coverDir, file.name)); err != nil {
+				continue
+			}
+			if _, used := referenced[file.name]; used {
+				cleared = append(cleared, file.name)
+			}
+			report.Evicted++
+			report.EvictedBytes += file.size
+			report.TotalBytes -= file.size
+			report.Files--
+		}
+		if err := clearCoverFiles(cleared); err != nil {
+			return report, err
+		}
+		report.Cleared = len(cleared)
+	}
+
+	return report, nil
+}
+
+// clearCoverFiles forgets evicted covers of library books, which show
+// without a cover until the next rebuild downloads them again
+func clearCoverFiles(fileNames []string) error {
+	if len(fileNames) == 0 {
+		return nil
+	}
+	db, err := openLibraryDatabase(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	for _, fileName := range fileNames {
+		if _, err := tx.Exec(`UPDATE books SET cover_file = NULL WHERE cover_file = ?`, fileName); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to clear cover %s: %w", fileName, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// listCoverFiles returns the finished covers in coverDir
+func listCoverFiles() ([]coverFile, error) {
+	entries, err := os.ReadDir(coverDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read covers directory: %w", err)
+	}
+
+	var files []coverFile
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, coverFile{
+			name:       entry.Name(),
+			size:       info.Size(),
+			accessedAt: accessTime(info),
+		})
+	}
+	return files, nil
+}
+
+// formatMB renders a byte count in megabytes
+func formatMB(bytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1024*1024))
+}
+
+// serveCoverMaintenance runs the maintenance and reports it as Alfred items
+func serveCoverMaintenance() {
+	limitBytes := int64(coverCacheMB) * 1024 * 1024
+	report, err := maintainCovers(limitBytes)
+	if err != nil {
+		serveErrorItem("Cover maintenance failed", err)
+		return
+	}
+	libraryBooks, err := fetchBookIDs()
+	if err != nil {
+		serveErrorItem("Cover maintenance failed", err)
+		return
+	}
+	pages, err := pruneDetailsPages(libraryBooks)
+	if err != nil {
+		serveErrorItem("Cover maintenance failed", err)
+		return
+	}
+
+	item := func(title, subtitle string) map[string]interface{} {
+		return map[string]interface{}{
+			"title":    title,
+			"subtitle": subtitle,
+			"valid":    false,
+			"icon": map[string]string{
+				"path": "icons/done.png",
+			},
+		}
+	}
+
+	items := []map[string]interface{}{
+		item(fmt.Sprintf("Cover cache: %d covers, %s", report.Files, formatMB(report.TotalBytes)),
+			fmt.Sprintf("Limit: %s (COVER_CACHE_MB)", formatMB(limitBytes))),
+		item(fmt.Sprintf("Removed %d unused covers", report.Orphans),
+			fmt.Sprintf("%s freed from covers of books no longer in your library", formatMB(report.OrphanBytes))),
+		item(fmt.Sprintf("Downloaded %d of %d corrupt covers again", report.Refetched, report.Broken),
+			"Empty files and error pages saved as covers are replaced"),
+		item(fmt.Sprintf("Evicted %d least recently used covers", report.Evicted),
+			fmt.Sprintf("%s freed to stay under the limit; %d library books show without a cover until ::hardcover-refresh",
+				formatMB(report.EvictedBytes), report.Cleared)),
+		item(fmt.Sprintf("Removed %d Quick Look pages", pages),
+			"Pages of books not in your library are written again when shown"),
+	}
+
+	jsonResult, err := json.MarshalIndent(map[string]interface{}{"items": items}, "", "  ")
+	if err != nil {
+		LogF("Error encoding JSON: %v", err)
+		return
+	}
+	fmt.Println(string(jsonResult))
+}
@@ -1,109 +1,77 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"math"
-	"net/http"
 	"os"
-	"path"
 	"time"
 
 	"path/filepath"
-	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
-func downloadImage(url string) error {
-
-	if url == "" { // Skip if the URL is empty
-		return nil
-	}
-	// Get the file name from the URL
-	fileName := filepath.Base(url)
-	if strings.Contains(fileName, "?") {
-		// Remove query parameters if present
-		fileName = strings.Split(fileName, "?")[0]
-	}
-
-	filePath := filepath.Join(coverDir, fileName)
-
-	// Check if the file already exists
-	if _, err := os.Stat(filePath); err == nil {
-		// fmt.Fprintln(os.Stderr, "skipped:", filePath)
-		return nil
-	}
-	// Download the file
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download %s: %w", url, err)
-	}
-	defer resp.Body.Close()
-
-	// Check if the HTTP response status is OK
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download %s: status code %d", url, resp.StatusCode)
-	}
-
-	// Create the file
-	file, err := os.Create(filePath)
+func createFTSTables(db sqlExecutor) error {
+	// Empty the FTS table first (its schema is owned by migrations.go)
+	_, err := db.Exec(`DELETE FROM books_authors_fts;`)
 	if err != nil {
-		return fmt.Errorf("failed to create file for %s: %w", url, err)
+		return fmt.Errorf("failed to clear FTS table: %w", err)
 	}
-	defer file.Close()
 
-	// Save the file
-	_, err = io.Copy(file, resp.Body)
+	// Insert data into FTS table
+	_, err = db.Exec(ftsRowsInsert(""))
 	if err != nil {
-		return fmt.Errorf("failed to save file for %s: %w", url, err)
+		return fmt.Errorf("failed to populate FTS table: %w", err)
 	}
 
-	fmt.Fprintln(os.Stderr, "Image saved:", filePath)
 	return nil
 }
 
-func createFTSTables(db *sql.DB) error {
-	// Drop and create the FTS table first
-	_, err := db.Exec(`DROP TABLE IF EXISTS books_authors_fts;
-	CREATE VIRTUAL TABLE books_authors_fts USING fts5(
-		book_id UNINDEXED,  -- Book ID (not used for searching)
-		title,              -- Book title (searchable)
-		authors             -- Concatenated author names (searchable)
-	);`)
-	if err != nil {
-		LogF("failed to create FTS table: %w", err)
-	}
+// ftsRowsInsert fills books_authors_fts from books and author, for the books
+// matching the optional WHERE filter. Indexed text is normalized (see
+// searchText.go); authors_display keeps the names as written.
+func ftsRowsInsert(filter string) string {
+	return `
+		INSERT INTO books_authors_fts (book_id, title, authors, authors_display)
+		SELECT
+			book_id,
+			normalize_search_text(IFNULL(title, '')),
+			normalize_search_text(authors),
+			authors
+		FROM (
+			SELECT
+				b.book_id,
+				b.title,
+				COALESCE((
+					SELECT GROUP_CONCAT(name, ', ')
+					FROM (SELECT DISTINCT name FROM author WHERE author.book_id = b.book_id)
+				), '') AS authors
+			FROM books b
+			` + filter + `
+		);`
+}
 
-	// Insert data into FTS table
-	_, err = db.Exec(`
-		INSERT INTO books_authors_fts (book_id, title, authors)
-		SELECT 
-			b.book_id, 
-			b.title, 
-			COALESCE((
-    SELECT GROUP_CONCAT(name, ', ') 
-    FROM (SELECT DISTINCT name FROM author WHERE author.book_id = b.book_id)
-), '') AS authors
-			
-			
-		FROM books b
-		LEFT JOIN author a ON b.book_id = a.book_id
-		GROUP BY b.book_id;
-	`)
-	if err != nil {
-		LogF("failed to populate FTS table: %w", err)
+// refreshFTSRows re-indexes the given books after an incremental change
+func refreshFTSRows(db sqlExecutor, bookIDs []int) error {
+	for _, bookID := range bookIDs {
+		if _, err := db.Exec(`DELETE FROM books_authors_fts WHERE book_id = ?`, bookID); err != nil {
+			return fmt.Errorf("failed to clear FTS row of book %d: %w", bookID, err)
+		}
+		if _, err := db.Exec(ftsRowsInsert(`WHERE b.book_id = ?`), bookID); err != nil {
+			return fmt.Errorf("failed to index book %d: %w", bookID, err)
+		}
 	}
-
 	return nil
 }
 
-func createRatingstable(db *sql.DB) error {
+func createRatingstable(db sqlExecutor) error {
 	// first: fetch all the ratings, and count the number of ratings for each book
-	// second: create the ratings table
+	// second: empty the ratings table
 	// third: insert the ratings into the ratings table
 
 	query := `SELECT user_rating FROM books WHERE user_rating IS NOT NULL`
@@ -134,16 +102,10 @@ func createRatingstable(db *sql.DB) error {
 
 	}
 
-	// Create the ratings table
-	_, err = db.Exec(`
-	DROP TABLE IF EXISTS ratings;
-	CREATE TABLE  ratings (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        rating REAL,
-        count INTEGER
-    )`)
+	// Empty the ratings table (its schema is owned by migrations.go)
+	_, err = db.Exec(`DELETE FROM ratings`)
 	if err != nil {
-		LogF("failed to create ratings table: %v", err)
+		LogF("failed to clear ratings table: %v", err)
 		return err
 	}
 
@@ -162,7 +124,7 @@ func createRatingstable(db *sql.DB) error {
 	return nil
 }
 
-func updateBookShelves(db *sql.DB) error {
+func updateBookShelves(db sqlExecutor) error {
 	// Query to get user_book_id and concatenated shelves
 	query := `
 		SELECT b.user_book_id, 
@@ -214,309 +176,296 @@ func updateBookShelves(db *sql.DB) error {
 	return nil
 }
 
-func createLibraryDatabase() ([]byte, error) {
-	// A function to fetch the user's library data from the API and store it in a SQLite database.
+// fetchAllPages runs a paged query until it returns a short page, handing
+// each response body to collect, which reports how many rows it held
+func fetchAllPages(query string, variables graphQLVars, pageSize int, collect func(body []byte) (int, error)) error {
+	for offset := 0; ; offset += pageSize {
+		pageVariables := graphQLVars{
+			"limit":  pageSize,
+			"offset": offset,
+		}
+		for name, value := range variables {
+			pageVariables[name] = value
+		}
 
-	// Start timing
-	startTime := time.Now()
+		body, err := interrogateAPI(newGraphQLRequest(query, pageVariables))
+		if err != nil {
+			return err
+		}
 
-	// GraphQL query for books in the user library
-	query := fmt.Sprintf(`query {
-  	user_books(where: {user_id: {_eq: %v}}) {
-    book {
-      id
-      title
-      rating
-      cached_image
-      cached_contributors
-      release_year
-      ratings_count
-      slug
-    }
-    id
-    status_id
-    rating
-    user_book_reads {
-      id
-      started_at
-      finished_at
-    }
-    edition {
-      isbn_10
-      isbn_13
+		rows, err := collect(body)
+		if err != nil {
+			return err
+		}
+		if rows < pageSize {
+			return nil
 		}
 	}
-	}
-	`, userID)
+}
 
-	// Fetch the user's library data
-	body, err := interrogateAPI(query)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to fetch library:", err)
-		return nil, err
-	}
+// fetchLibraryPages pages through the user's library, loading each page
+// into the database as soon as it arrives
+func fetchLibraryPages(db *sql.DB) (int, error) {
+	totalBooks := 0
+	err := fetchAllPages(libraryBooksQuery, graphQLVars{"userID": userID}, libraryPageSize, func(body []byte) (int, error) {
+		var APILibrary APILibrary
+		if err := json.Unmarshal(body, &APILibrary); err != nil {
+			return 0, fmt.Errorf("error decoding library JSON response: %w", err)
+		}
 
-	var APILibrary APILibrary
-	err = json.Unmarshal(body, &APILibrary)
-	if err != nil {
-		LogF("Error decoding library JSON response: %v", err)
-		return nil, err
-	}
+		tx, err := db.Begin()
+		if err != nil {
+			return 0, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		insertLibraryPage(tx, APILibrary.Data.UserBooks)
+		if err := tx.Commit(); err != nil {
+			return 0, fmt.Errorf("failed to commit library page: %w", err)
+		}
 
-	// 2. get the books on shelves (including those with no reading status)
-	query = fmt.Sprintf(`query {
-
-	lists(where: {user_id: {_eq: %v}}) {
-		id	
-		name
-		books_count
-		list_books {
-			id
-			book_id
-		book {
-			cached_contributors
-			title
-			release_year
-			cached_image
-			rating
-			ratings_count
-			slug
+		totalBooks += len(APILibrary.Data.UserBooks)
+		LogF("Library: %d books loaded", totalBooks)
+		return len(APILibrary.Data.UserBooks), nil
+	})
+	return totalBooks, err
+}
 
+// insertLibraryPage stores a page of user_books with their reads and authors
+func insertLibraryPage(tx *sql.Tx, userBooks []UserBook) {
+	for _, userBook := range userBooks {
+		if err := upsertUserBook(tx, userBook); err != nil {
+			log.Printf("Failed to insert book: %v", err)
 		}
-		user_books(where: {user_id: {_eq: %v}}) {
-        id
-      }
-    }
-  }
-}`, userID, userID)
-	// Fetch the user's library data
-	body, err = interrogateAPI(query)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to fetch shelves:", err)
-		return nil, err
-	}
-	var APIshelf APIshelf
-	err = json.Unmarshal(body, &APIshelf)
-	if err != nil {
-		LogF("Error decoding shelf JSON response: %v", err)
-		return nil, err
 	}
+}
 
-	// Open SQLite database
-	db, err := sql.Open("sqlite3", databasePath)
-	if err != nil {
-		LogF("failed to open SQLite database: %v", err)
-		return nil, err
+// upsertUserBook inserts or refreshes a library book, replacing its reads
+// and authors. A shelf-only row for the same book is promoted to the library.
+func upsertUserBook(tx *sql.Tx, userBook UserBook) error {
+	book := userBook.Book
+
+	// Round rating to 2 decimals
+	rating := math.Round(book.Rating*100) / 100
+
+	// Queue the book cover download if not already downloaded
+	covers.Queue(userBook.Book.CachedImage.URL)
+	coverFile := coverFileName(userBook.Book.CachedImage.URL)
+	pages, audioSeconds := userBook.length()
+
+	// a book that was only on shelves keeps its shelf rows
+	var previousUserBookID sql.NullInt64
+	err := tx.QueryRow(`SELECT user_book_id FROM books WHERE book_id = ?`, book.ID).Scan(&previousUserBookID)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to look up book %d: %w", book.ID, err)
+	}
+	if previousUserBookID.Valid && previousUserBookID.Int64 != int64(userBook.ID) {
+		if _, err := tx.Exec(`UPDATE shelf SET user_book_id = ? WHERE user_book_id = ?`, userBook.ID, previousUserBookID.Int64); err != nil {
+			return fmt.Errorf("failed to move shelves of book %d: %w", book.ID, err)
+		}
 	}
 
-	_, err = db.Exec("PRAGMA journal_mode=WAL;")
+	// Insert into books table
+	_, err = tx.Exec(
+		`INSERT INTO books (book_id, user_book_id, user_rating, status_id, title, rating, ratings_count, release_year, image_url, cover_file, isbn_10, isbn_13,slug, date_added, description, pages, audio_seconds)
+		VALUES (?, ?, IFNULL(?, 0), ?, ?, ?, ?, ?, ?, ?, ?, ?,?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, 0))
+		ON CONFLICT(book_id) DO UPDATE SET
+			user_book_id = excluded.user_book_id,
+			user_rating = excluded.user_rating,
+			status_id = excluded.status_id,
+			title = excluded.title,
+			rating = excluded.rating,
+			ratings_count = excluded.ratings_count,
+			release_year = excluded.release_year,
+			image_url = excluded.image_url,
+			cover_file = excluded.cover_file,
+			isbn_10 = excluded.isbn_10,
+			isbn_13 = excluded.isbn_13,
+			slug = excluded.slug,
+			date_added = excluded.date_added,
+			description = excluded.description,
+			pages = excluded.pages,
+			audio_seconds = excluded.audio_seconds`,
+		book.ID, userBook.ID, userBook.Rating, userBook.StatusID, book.Title, rating, book.RatingsCount, book.ReleaseYear, userBook.Book.CachedImage.URL, coverFile, userBook.Edition.ISBN10, userBook.Edition.ISBN13, book.Slug, userBook.DateAdded, book.Description, pages, audioSeconds,
+	)
 	if err != nil {
-		log.Fatalf("Failed to enable WAL mode: %v", err)
+		return fmt.Errorf("failed to store book %d: %w", book.ID, err)
 	}
 
-	defer db.Close()
-
-	// Create tables
-	tableCreationQueries := []string{
-		`DROP TABLE IF EXISTS books;
-		CREATE TABLE books (
-		book_id INTEGER PRIMARY KEY, 
-		user_book_id INTEGER UNIQUE,
-		user_rating REAL,
-		status_id INTEGER,
-		title TEXT,
-		rating REAL,
-		ratings_count INTEGER,
-		release_year INTEGER,
-		image_url TEXT,
-		cover_file TEXT,
-		isbn_10 TEXT,
-		isbn_13 TEXT,
-		slug TEXT,
-		shelves TEXT
-		);
-		CREATE INDEX idx_books_status ON books(status_id);`,
-
-		`DROP TABLE IF EXISTS journey;
-		CREATE TABLE journey (
-		journey_id INTEGER PRIMARY KEY,
-		user_book_id INTEGER,
-		started_at TEXT,
-		finished_at TEXT,
-		FOREIGN KEY(user_book_id) REFERENCES books(user_book_id)
-		)`,
-
-		`DROP TABLE IF EXISTS author;
-		CREATE TABLE author (
-		ID INTEGER PRIMARY KEY AUTOINCREMENT,
-		book_id INTEGER,
-		name TEXT,
-		contribution TEXT,
-		FOREIGN KEY(book_id) REFERENCES books(book_id)
-		);
-		CREATE INDEX idx_author_book ON author(book_id);
-		`,
-
-		`DROP TABLE IF EXISTS shelf;
-		CREATE TABLE  shelf (
-		ID INTEGER PRIMARY KEY AUTOINCREMENT,
-		shelf_id INTEGER,
-		user_book_id INTEGER,
-		list_book_id INTEGER,
-		name TEXT,
-		FOREIGN KEY(user_book_id) REFERENCES books(user_book_id)
-		);
-		CREATE INDEX idx_shelf_userbook ON shelf(user_book_id);`,
-
-		`DROP TABLE IF EXISTS bookshelves;
-		CREATE TABLE bookshelves (
-		shelf_id INTEGER PRIMARY KEY,
-		name TEXT,
-		books_count INTEGER,
-		public BOOLEAN,
-		slug TEXT,
-		FOREIGN KEY(shelf_id) REFERENCES shelf(shelf_id)
-		)`,
-	}
-
-	for _, query := range tableCreationQueries {
-		if _, err := db.Exec(query); err != nil {
-			log.Printf("failed to create table: %v", err)
+	// Insert into journey table
+	if _, err := tx.Exec(`DELETE FROM journey WHERE user_book_id = ?`, userBook.ID); err != nil {
+		return fmt.Errorf("failed to clear journey of book %d: %w", book.ID, err)
+	}
+	for _, read := range userBook.UserBookReads {
+		_, err = tx.Exec(
+			`INSERT OR REPLACE INTO journey (journey_id, user_book_id, started_at, finished_at, progress_pages, progress_seconds)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			read.ID, userBook.ID, read.StartedAt, read.FinishedAt, read.ProgressPages, read.ProgressSeconds,
+		)
+		if err != nil {
+			LogF("Failed to insert journey: %v", err)
 		}
 	}
 
-	// Populating tables
-	for _, userBook := range APILibrary.Data.UserBooks {
-		book := userBook.Book
+	return replaceAuthors(tx, book.ID, book.CachedContributors)
+}
 
-		// Round rating to 2 decimals
-		rating := math.Round(book.Rating*100) / 100
+// replaceAuthors rewrites the author rows of a book
+func replaceAuthors(tx *sql.Tx, bookID int, contributors []ContributorEntry) error {
+	if _, err := tx.Exec(`DELETE FROM author WHERE book_id = ?`, bookID); err != nil {
+		return fmt.Errorf("failed to clear authors of book %d: %w", bookID, err)
+	}
+	// Insert into author table
+	for _, contribution := range contributors {
+		_, err := tx.Exec(
+			`INSERT INTO author (book_id, name, contribution)
+			VALUES (?, ?, ?)`,
+			bookID, contribution.Author.Name, contribution.Contribution,
+		)
+		if err != nil {
+			log.Printf("Failed to insert author: %v", err)
+		}
+	}
 
-		// Download the book cover image if not already downloaded
-		downloadImage(userBook.Book.CachedImage.URL)
+	// first author's surname, for sorting
+	var surname string
+	if len(contributors) > 0 {
+		surname = authorSurname(contributors[0].Author.Name)
+	}
+	if _, err := tx.Exec(`UPDATE books SET author_sort = ? WHERE book_id = ?`, surname, bookID); err != nil {
+		return fmt.Errorf("failed to store author sort name of book %d: %w", bookID, err)
+	}
+	return nil
+}
 
-		// Extract cover file name from image_url
-		coverFile := ""
-		if userBook.Book.CachedImage.URL != "" {
-			coverFile = path.Base(userBook.Book.CachedImage.URL)
+// fetchShelfPages pages through the books on the user's shelves, loading
+// each page into the database as soon as it arrives
+func fetchShelfPages(db *sql.DB) (int, error) {
+	totalEntries := 0
+	err := fetchAllPages(shelfBooksQuery, graphQLVars{"userID": userID}, shelfPageSize, func(body []byte) (int, error) {
+		var APIListBooks APIListBooks
+		if err := json.Unmarshal(body, &APIListBooks); err != nil {
+			return 0, fmt.Errorf("error decoding shelf JSON response: %w", err)
 		}
 
-		// Insert into books table
-		_, err := db.Exec(
-			`INSERT INTO books (book_id, user_book_id, user_rating, status_id, title, rating, ratings_count, release_year, image_url, cover_file, isbn_10, isbn_13,slug)
-		VALUES (?, ?, IFNULL(?, 0), ?, ?, ?, ?, ?, ?, ?, ?, ?,?)`,
-			book.ID, userBook.ID, userBook.Rating, userBook.StatusID, book.Title, rating, book.RatingsCount, book.ReleaseYear, userBook.Book.CachedImage.URL, coverFile, userBook.Edition.ISBN10, userBook.Edition.ISBN13, book.Slug,
-		)
+		tx, err := db.Begin()
 		if err != nil {
-			log.Printf("Failed to insert book: %v", err)
-			continue
+			return 0, fmt.Errorf("failed to begin transaction: %w", err)
 		}
-		// Insert into journey table
-		for _, read := range userBook.UserBookReads {
-			_, err = db.Exec(
-				`INSERT INTO journey (journey_id, user_book_id, started_at, finished_at)
-			VALUES (?, ?, ?, ?)`,
-				read.ID, userBook.ID, read.StartedAt, read.FinishedAt,
-			)
-			if err != nil {
-				LogF("Failed to insert journey: %v", err)
-			}
+		insertShelfPage(tx, APIListBooks.Data.ListBooks)
+		if err := tx.Commit(); err != nil {
+			return 0, fmt.Errorf("failed to commit shelf page: %w", err)
 		}
 
-		// Insert into author table
-		for _, contribution := range book.CachedContributors {
-			_, err = db.Exec(
-				`INSERT INTO author (book_id, name, contribution)
-			VALUES (?, ?, ?)`,
-				book.ID, contribution.Author.Name, contribution.Contribution,
-			)
-			if err != nil {
-				log.Printf("Failed to insert author: %v", err)
-			}
+		totalEntries += len(APIListBooks.Data.ListBooks)
+		LogF("Shelves: %d entries loaded", totalEntries)
+		return len(APIListBooks.Data.ListBooks), nil
+	})
+	return totalEntries, err
+}
 
+// insertShelfPage stores a page of list_books
+func insertShelfPage(tx *sql.Tx, listBooks []ListBook) {
+	for _, listBook := range listBooks {
+		if err := upsertListBook(tx, listBook); err != nil {
+			log.Printf("Failed to insert shelf: %v", err)
 		}
 	}
+}
 
-	// Insert into shelf table
-	shelfOnlyCounter := 0
-	for _, list := range APIshelf.Data.Lists {
-		for _, listBook := range list.ListBooks {
-			var userBookID int
-			if len(listBook.UserBooks) > 0 {
-				userBookID = listBook.UserBooks[0].ID
-			} else {
-				shelfOnlyCounter--
-				userBookID = shelfOnlyCounter
-				//if userBookID is nil, then the book is not in the user's library
-				// adding the book to the main library
-
-				// Round rating to 2 decimals
-				rating := math.Round(listBook.Book.Rating*100) / 100
-				// Download the book cover image if not already downloaded
-				downloadImage(listBook.Book.CachedImage.URL)
-
-				// Extract cover file name from image_url
-				coverFile := ""
-				if listBook.Book.CachedImage.URL != "" {
-					coverFile = path.Base(listBook.Book.CachedImage.URL)
-				}
-
-				_, err := db.Exec(
-					`INSERT INTO books (book_id, user_book_id, user_rating, status_id, title, rating, ratings_count, release_year, image_url, cover_file, isbn_10, isbn_13,slug)
-				VALUES (?,
-				?,
-				NULL,
-				0,
-				?,
-				?,
-				?,
-				?,
-				?,
-				?,
-				NULL,
-				NULL,
-				?)`,
-					listBook.BookID, userBookID, listBook.Book.Title, rating, listBook.Book.RatingsCount, listBook.Book.ReleaseYear, listBook.Book.CachedImage.URL, coverFile, listBook.Book.Slug,
-				)
-				if err != nil {
-					log.Printf("Failed to insert book without userBookID: %v", err)
-					continue
-				}
-			}
-			// Insert into author table
-			for _, contribution := range listBook.Book.CachedContributors {
-				_, err = db.Exec(
-					`INSERT INTO author (book_id, name, contribution)
-			VALUES (?, ?, ?)`,
-					listBook.BookID, contribution.Author.Name, contribution.Contribution,
-				)
-				if err != nil {
-					log.Printf("Failed to insert author: %v", err)
-				}
-			}
-			_, err = db.Exec(
-				`INSERT INTO shelf (shelf_id, user_book_id, list_book_id, name)
-				VALUES (?, ?, ?,?)`,
-				list.ID, userBookID, listBook.ID, list.Name,
-			)
-			if err != nil {
-				log.Printf("Failed to insert shelf: %v", err)
-			}
+// upsertListBook stores a shelf entry, adding the book to the main library
+// when it is only on shelves
+func upsertListBook(tx *sql.Tx, listBook ListBook) error {
+	var userBookID int
+	if len(listBook.UserBooks) > 0 {
+		userBookID = listBook.UserBooks[0].ID
+	} else {
+		var err error
+		userBookID, err = shelfOnlyUserBookID(tx, listBook)
+		if err != nil {
+			return err
 		}
 	}
 
-	// populate the bookshelves table
+	if _, err := tx.Exec(`DELETE FROM shelf WHERE list_book_id = ?`, listBook.ID); err != nil {
+		return fmt.Errorf("failed to clear shelf entry %d: %w", listBook.ID, err)
+	}
+	_, err := tx.Exec(
+		`INSERT INTO shelf (shelf_id, user_book_id, list_book_id, name)
+		VALUES (?, ?, ?,?)`,
+		listBook.List.ID, userBookID, listBook.ID, listBook.List.Name,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store shelf entry %d: %w", listBook.ID, err)
+	}
+	return nil
+}
+
+// shelfOnlyUserBookID returns the user_book_id of a book found on a shelf
+// but not in the user's library. Such books are added to the main library
+// under a negative user_book_id, shared by all their shelf entries.
+func shelfOnlyUserBookID(tx *sql.Tx, listBook ListBook) (int, error) {
+	var userBookID int
+	err := tx.QueryRow(`SELECT user_book_id FROM books WHERE book_id = ?`, listBook.BookID).Scan(&userBookID)
+	if err == nil {
+		return userBookID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to look up book %d: %w", listBook.BookID, err)
+	}
+
+	if err := tx.QueryRow(`SELECT MIN(IFNULL(MIN(user_book_id), 0), 0) - 1 FROM books`).Scan(&userBookID); err != nil {
+		return 0, fmt.Errorf("failed to allocate user_book_id: %w", err)
+	}
+
+	// Round rating to 2 decimals
+	rating := math.Round(listBook.Book.Rating*100) / 100
+	// Queue the book cover download if not already downloaded
+	covers.Queue(listBook.Book.CachedImage.URL)
+	coverFile := coverFileName(listBook.Book.CachedImage.URL)
+
+	_, err = tx.Exec(
+		`INSERT INTO books (book_id, user_book_id, user_rating, status_id, title, rating, ratings_count, release_year, image_url, cover_file, isbn_10, isbn_13,slug, date_added, description)
+		VALUES (?,
+		?,
+		NULL,
+		0,
+		?,
+		?,
+		?,
+		?,
+		?,
+		?,
+		NULL,
+		NULL,
+		?,
+		NULLIF(?, ''),
+		NULLIF(?, ''))`,
+		listBook.BookID, userBookID, listBook.Book.Title, rating, listBook.Book.RatingsCount, listBook.Book.ReleaseYear, listBook.Book.CachedImage.URL, coverFile, listBook.Book.Slug, listBook.DateAdded, listBook.Book.Description,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert book without userBookID: %w", err)
+	}
+
+	return userBookID, replaceAuthors(tx, listBook.BookID, listBook.Book.CachedContributors)
+}
+
+// storeBookshelves replaces the bookshelves table with the user's lists
+func storeBookshelves(db sqlExecutor) error {
 	shelves, err := fetchUserShelves()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error fetching shelves:", err)
-		return nil, err
+		return err
 	}
 	// Unmarshal the JSON data into ShelfJSON
 	var shelfData ShelfJSON
 	err = json.Unmarshal(shelves, &shelfData)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error unmarshalling shelves data:", err)
-		return nil, err
+		return fmt.Errorf("error unmarshalling shelves data: %w", err)
+	}
+	if len(shelfData.Data.Me) == 0 {
+		return fmt.Errorf("the API returned no user for this token")
+	}
+
+	if _, err := db.Exec(`DELETE FROM bookshelves`); err != nil {
+		return fmt.Errorf("failed to clear bookshelves: %w", err)
 	}
 	for _, shelf := range shelfData.Data.Me[0].Lists {
 		_, err = db.Exec(
@@ -528,15 +477,58 @@ func createLibraryDatabase() ([]byte, error) {
 			log.Printf("Failed to insert shelf: %v", err)
 		}
 	}
+	return nil
+}
 
-	// Call function to update shelves field
-	if err := updateBookShelves(db); err != nil {
-		log.Fatalf("Error updating shelves: %v", err)
+// saveLastUpdatedLocal records when the local database was last refreshed
+func saveLastUpdatedLocal() {
+	// Get current time in UTC
+	today := time.Now().UTC().Format("2006-01-02T15:04:05.000000-07:00")
+
+	// Define the file name
+	fileName := filepath.Join(dataFolder, "lastUpdatedLocal")
+
+	// Write the formatted timestamp to the file
+	err := os.WriteFile(fileName, []byte(today), 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save date to file:", err)
+	}
+}
+
+// rebuildStats counts what the API returned during a rebuild, so the
+// staging database can be checked against it
+type rebuildStats struct {
+	UserBooks int
+	ListBooks int
+}
+
+func createLibraryDatabase() ([]byte, error) {
+	// A function to fetch the user's library data from the API and store it in a SQLite database.
+	// The new database is written to a staging file and swapped in only once
+	// complete and verified: on failure the current library stays untouched.
+
+	// Start timing
+	startTime := time.Now()
+
+	stagingPath := databasePath + ".staging"
+	removeDatabaseFiles(stagingPath)
+
+	stats, err := populateLibraryDatabase(stagingPath)
+	if err != nil {
+		removeDatabaseFiles(stagingPath)
+		fmt.Fprintln(os.Stderr, "Database rebuild failed:", err)
+		serveErrorItem("Database rebuild failed, your library was left untouched", err)
+		return nil, err
 	}
 
-	createRatingstable(db)
+	if err := swapDatabase(stagingPath, databasePath); err != nil {
+		removeDatabaseFiles(stagingPath)
+		fmt.Fprintln(os.Stderr, "Database swap failed:", err)
+		serveErrorItem("Database rebuild failed, your library was left untouched", err)
+		return nil, err
+	}
+	LogF("Database rebuilt: %d library books, %d shelf entries", stats.UserBooks, stats.ListBooks)
 
-	createFTSTables(db)
 	// Create the result object
 	result := map[string][]map[string]interface{}{
 		"items": {map[string]interface{}{
@@ -558,20 +550,186 @@ func createLibraryDatabase() ([]byte, error) {
 	}
 	fmt.Println(string(jsonResult))
 
-	// Get current time in UTC
-	today := time.Now().UTC().Format("2006-01-02T15:04:05.000000-07:00")
-
-	// Define the file name
-	fileName := filepath.Join(dataFolder, "lastUpdatedLocal")
-
-	// Write the formatted timestamp to the file
-	err = os.WriteFile(fileName, []byte(today), 0644)
-	if err != nil {
-		fmt.Println("Failed to save date to file:", err)
-	}
+	saveLastUpdatedLocal()
+	// the next incremental sync starts from the beginning of this rebuild
+	saveLastSync(startTime)
+	clearFullSync()
 
 	elapsedTime := time.Since(startTime)
 	LogF("Database rebuild execution time: %d ms", elapsedTime.Milliseconds())
 	os.Exit(0)
 	return nil, nil
 }
+
+// populateLibraryDatabase creates a complete library database at dbPath
+// and verifies it
+func populateLibraryDatabase(dbPath string) (rebuildStats, error) {
+	var stats rebuildStats
+
+	// Open SQLite database: the migrations create the tables
+	db, err := openLibraryDatabase(dbPath)
+	if err != nil {
+		return stats, err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("PRAGMA journal_mode=WAL;")
+	if err != nil {
+		return stats, fmt.Errorf("failed to enable WAL mode: %w", err)
+	}
+
+	// Populating tables, one page at a time
+	stats.UserBooks, err = fetchLibraryPages(db)
+	if err != nil {
+		return stats, fmt.Errorf("could not fetch your library: %w", err)
+	}
+
+	// get the books on shelves (including those with no reading status)
+	stats.ListBooks, err = fetchShelfPages(db)
+	if err != nil {
+		return stats, fmt.Errorf("could not fetch your shelves: %w", err)
+	}
+
+	// populate the bookshelves table
+	if err := storeBookshelves(db); err != nil {
+		return stats, fmt.Errorf("could not fetch your shelves: %w", err)
+	}
+
+	// Call function to update shelves field
+	if err := updateBookShelves(db); err != nil {
+		return stats, err
+	}
+
+	if err := createRatingstable(db); err != nil {
+		return stats, err
+	}
+
+	if err := createFTSTables(db); err != nil {
+		return stats, err
+	}
+
+	// covers were queued while the pages were stored
+	covers.Wait()
+
+	return stats, verifyLibraryDatabase(db, stats)
+}
+
+// verifyLibraryDatabase checks a freshly built database before it replaces
+// the current one
+func verifyLibraryDatabase(db *sql.DB, stats rebuildStats) error {
+	var integrity string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&integrity); err != nil {
+		return fmt.Errorf("integrity check failed: %w", err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("integrity check failed: %s", integrity)
+	}
+
+	var libraryBooks, shelfEntries, books, ftsRows, ratedBooks, ratingsTotal int
+	counts := []struct {
+		query  string
+		target *int
+	}{
+		{`SELECT COUNT(*) FROM books WHERE user_book_id > 0`, &libraryBooks},
+		{`SELECT COUNT(*) FROM shelf`, &shelfEntries},
+		{`SELECT COUNT(*) FROM books`, &books},
+		{`SELECT COUNT(*) FROM books_authors_fts`, &ftsRows},
+		// the ratings table has a bucket per half star from 0 to 5
+		{`SELECT COUNT(*) FROM books WHERE user_rating BETWEEN 0 AND 5 AND user_rating * 2 = CAST(user_rating * 2 AS INTEGER)`, &ratedBooks},
+		{`SELECT IFNULL(SUM(count), 0) FROM ratings`, &ratingsTotal},
+	}
+	for _, count := range counts {
+		if err := db.QueryRow(count.query).Scan(count.target); err != nil {
+			return fmt.Errorf("failed to count rows: %w", err)
+		}
+	}
+
+	if libraryBooks != stats.UserBooks {
+		return fmt.Errorf("stored %d of %d library books", libraryBooks, stats.UserBooks)
+	}
+	if shelfEntries != stats.ListBooks {
+		return fmt.Errorf("stored %d of %d shelf entries", shelfEntries, stats.ListBooks)
+	}
+	if ftsRows != books {
+		return fmt.Errorf("search index has %d rows for %d books", ftsRows, books)
+	}
+	if ratingsTotal != ratedBooks {
+		return fmt.Errorf("ratings table counts %d of %d rated books", ratingsTotal, ratedBooks)
+	}
+	return nil
+}
+
+// swapDatabase copies the staging database over the live one with the
+// SQLite backup API. The copy is a single write transaction of the live
+// database, so readers see either the old library or the new one and its
+// WAL stays consistent; the staging files are removed afterwards.
+func swapDatabase(stagingPath, livePath string) error {
+	live, err := sql.Open(libraryDriverName, livePath+"?_busy_timeout=5000")
+	if err != nil {
+		return fmt.Errorf("failed to open the database: %w", err)
+	}
+	defer live.Close()
+	staging, err := sql.Open(libraryDriverName, stagingPath)
+	if err != nil {
+		return fmt.Errorf("failed to open the new database: %w", err)
+	}
+	defer staging.Close()
+
+	ctx := context.Background()
+	liveConn, err := live.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open the database: %w", err)
+	}
+	defer liveConn.Close()
+	stagingConn, err := staging.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open the new database: %w", err)
+	}
+	defer stagingConn.Close()
+
+	err = liveConn.Raw(func(liveDriver interface{}) error {
+		return stagingConn.Raw(func(stagingDriver interface{}) error {
+			return copyDatabase(liveDriver.(*sqlite3.SQLiteConn), stagingDriver.(*sqlite3.SQLiteConn))
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace the database: %w", err)
+	}
+	stagingConn.Close()
+	staging.Close()
+	removeDatabaseFiles(stagingPath)
+	return nil
+}
+
+// copyDatabase runs a backup from source to destination, retrying while
+// another invocation holds the destination's write lock
+func copyDatabase(destination, source *sqlite3.SQLiteConn) error {
+	backup, err := destination.Backup("main", source, "main")
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		done, err := backup.Step(-1)
+		if done {
+			return backup.Finish()
+		}
+		var sqliteErr sqlite3.Error
+		busy := errors.As(err, &sqliteErr) &&
+			(sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+		if err != nil && (!busy || time.Now().After(deadline)) {
+			backup.Finish()
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// removeDatabaseFiles deletes a database file with its WAL and journal files
+func removeDatabaseFiles(dbPath string) {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			LogF("Failed to remove %s: %v", dbPath+suffix, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSwapDatabase(t *testing.T) {
+	dir := t.TempDir()
+	livePath := filepath.Join(dir, "library.db")
+	stagingPath := livePath + ".staging"
+
+	create := func(path string, value int) *sql.DB {
+		db, err := sql.Open(libraryDriverName, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, statement := range []string{
+			"PRAGMA journal_mode=WAL",
+			"CREATE TABLE t (x INTEGER)",
+		} {
+			if _, err := db.Exec(statement); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.Exec("INSERT INTO t VALUES (?)", value); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	// a reader keeps the live database open during the swap
+	reader := create(livePath, 1)
+	defer reader.Close()
+	create(stagingPath, 2).Close()
+
+	if err := swapDatabase(stagingPath, livePath); err != nil {
+		t.Fatal(err)
+	}
+	var x int
+	if err := reader.QueryRow("SELECT x FROM t").Scan(&x); err != nil || x != 2 {
+		t.Errorf("open reader sees %d (%v), want 2", x, err)
+	}
+	fresh, err := sql.Open(libraryDriverName, livePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	if err := fresh.QueryRow("SELECT x FROM t").Scan(&x); err != nil || x != 2 {
+		t.Errorf("new reader sees %d (%v), want 2", x, err)
+	}
+	if _, err := os.Stat(stagingPath); !os.IsNotExist(err) {
+		t.Errorf("staging database left behind: %v", err)
+	}
+}
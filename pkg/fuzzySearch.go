@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Typo tolerance for library search: when the full-text query finds fewer
+// than fuzzyThreshold books, titles and author names are compared word by
+// word with the search words using the optimal string alignment distance
+// (insertions, deletions, substitutions and transpositions). Books found
+// this way are shown as approximate matches after the exact ones.
+
+const (
+	fuzzyThreshold  = 3
+	fuzzyMaxMatches = 50 // approximate matches shown at most
+)
+
+// ftsMatchClause is the clause compile emits for the positive text terms
+const ftsMatchClause = "(books_authors_fts MATCH ?)"
+
+// fuzzyDocument is the searchable text of one library book
+type fuzzyDocument struct {
+	BookID  int
+	Title   string
+	Authors string
+}
+
+// fuzzyMatch is a book found by the fuzzy layer; Distance 0 is an exact word
+type fuzzyMatch struct {
+	BookID   int
+	Distance int
+}
+
+// fuzzyTerms returns the positive text, title and author terms of a query
+func (q libraryQuery) fuzzyTerms() []queryTerm {
+	var terms []queryTerm
+	for _, term := range q.Terms {
+		if term.Negated {
+			continue
+		}
+		if isTextField(term.Field) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// searchWords splits text into normalized words
+func searchWords(text string) []string {
+	return strings.Fields(normalizeSearchText(text))
+}
+
+// maxTypos is how many edits a search word of this length tolerates
+func maxTypos(word []rune) int {
+	switch {
+	case len(word) <= 3:
+		return 0
+	case len(word) <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// osaDistance is the optimal string alignment distance between a and b
+func osaDistance(a, b []rune) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := 0; j <= len(b); j++ {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			best := rows[i-1][j] + 1 // deletion
+			if insertion := rows[i][j-1] + 1; insertion < best {
+				best = insertion
+			}
+			if substitution := rows[i-1][j-1] + cost; substitution < best {
+				best = substitution
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				if transposition := rows[i-2][j-2] + 1; transposition < best {
+					best = transposition
+				}
+			}
+			rows[i][j] = best
+		}
+	}
+	return rows[len(a)][len(b)]
+}
+
+// wordDistance compares a search word with a word of the book; as in the
+// full-text search, the search word may be the beginning of the book word
+func wordDistance(search, word []rune) int {
+	distance := osaDistance(search, word)
+	if len(word) > len(search) {
+		if prefix := osaDistance(search, word[:len(search)]); prefix < distance {
+			distance = prefix
+		}
+	}
+	return distance
+}
+
+// rankFuzzyMatches returns the documents where every search word is within
+// its typo budget of some word in the field it targets, closest first
+func rankFuzzyMatches(documents []fuzzyDocument, terms []queryTerm) []fuzzyMatch {
+	type searchWord struct {
+		runes []rune
+		field queryField
+	}
+	var words []searchWord
+	for _, term := range terms {
+		for _, word := range searchWords(term.Value) {
+			words = append(words, searchWord{[]rune(word), term.Field})
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	var matches []fuzzyMatch
+	for _, document := range documents {
+		titleWords := toRunes(searchWords(document.Title))
+		authorWords := toRunes(searchWords(document.Authors))
+
+		total := 0
+		matched := true
+		for _, word := range words {
+			var candidates [][]rune
+			switch word.field {
+			case fieldTitle:
+				candidates = titleWords
+			case fieldAuthor:
+				candidates = authorWords
+			default:
+				candidates = append(append([][]rune{}, titleWords...), authorWords...)
+			}
+
+			best := -1
+			for _, candidate := range candidates {
+				if distance := wordDistance(word.runes, candidate); best < 0 || distance < best {
+					best = distance
+				}
+			}
+			if best < 0 || best > maxTypos(word.runes) {
+				matched = false
+				break
+			}
+			total += best
+		}
+		if matched {
+			matches = append(matches, fuzzyMatch{BookID: document.BookID, Distance: total})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+	return matches
+}
+
+func toRunes(words []string) [][]rune {
+	runes := make([][]rune, len(words))
+	for i, word := range words {
+		runes[i] = []rune(word)
+	}
+	return runes
+}
+
+// fuzzyLibraryMatches runs the fuzzy layer over the whole library
+func fuzzyLibraryMatches(db *sql.DB, terms []queryTerm) ([]fuzzyMatch, error) {
+	rows, err := db.Query(`SELECT book_id, IFNULL(title, ''), IFNULL(authors, '') FROM books_authors_fts`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read library for fuzzy search: %w", err)
+	}
+	defer rows.Close()
+
+	var documents []fuzzyDocument
+	for rows.Next() {
+		var document fuzzyDocument
+		if err := rows.Scan(&document.BookID, &document.Title, &document.Authors); err != nil {
+			return nil, fmt.Errorf("failed to scan library row: %w", err)
+		}
+		documents = append(documents, document)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rankFuzzyMatches(documents, terms), nil
+}
+
+// matchingBookIDs returns the books selected by the WHERE clauses of a
+// library query
+func matchingBookIDs(db *sql.DB, whereClauses []string, args []interface{}) ([]int, error) {
+	query := `SELECT DISTINCT b.book_id
+		FROM books_authors_fts f
+		JOIN books b ON f.book_id = b.book_id
+		LEFT JOIN shelf s ON b.user_book_id = s.user_book_id`
+	if len(whereClauses) > 0 {
+		query += " WHERE " + strings.Join(whereClauses, " AND ")
+	}
+	return queryIDs(db, query, args...)
+}
+
+// widenWithFuzzyMatches replaces the full-text clause of a library query with
+// the exact hits plus the fuzzy ones. It returns the new clauses and
+// arguments, the books in display order, and the approximate ones.
+func widenWithFuzzyMatches(db *sql.DB, parsedQuery libraryQuery, whereClauses []string, args []interface{}) ([]string, []interface{}, []int, map[int]bool, error) {
+	terms := parsedQuery.fuzzyTerms()
+	if len(terms) == 0 || len(whereClauses) == 0 || whereClauses[0] != ftsMatchClause {
+		return whereClauses, args, nil, nil, nil
+	}
+
+	exactIDs, err := matchingBookIDs(db, whereClauses, args)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if len(exactIDs) >= fuzzyThreshold {
+		return whereClauses, args, nil, nil, nil
+	}
+
+	matches, err := fuzzyLibraryMatches(db, terms)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	ordered := append([]int{}, exactIDs...)
+	exact := make(map[int]bool)
+	for _, id := range exactIDs {
+		exact[id] = true
+	}
+	approximate := make(map[int]bool)
+	for _, match := range matches {
+		if len(approximate) == fuzzyMaxMatches {
+			break
+		}
+		if !exact[match.BookID] {
+			approximate[match.BookID] = true
+			ordered = append(ordered, match.BookID)
+		}
+	}
+	if len(approximate) == 0 {
+		return whereClauses, args, nil, nil, nil
+	}
+
+	// the other filters still apply to the approximate matches
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ordered)), ", ")
+	widenedClauses := append([]string{"b.book_id IN (" + placeholders + ")"}, whereClauses[1:]...)
+	var widenedArgs []interface{}
+	for _, id := range ordered {
+		widenedArgs = append(widenedArgs, id)
+	}
+	widenedArgs = append(widenedArgs, args[1:]...)
+
+	LogF("Fuzzy search: %d exact, %d approximate matches", len(exactIDs), len(approximate))
+	return widenedClauses, widenedArgs, ordered, approximate, nil
+}
+
+// fuzzyRankClause keeps books in the given order
+func fuzzyRankClause(ordered []int) (string, []interface{}) {
+	var clause strings.Builder
+	var args []interface{}
+	clause.WriteString(" ORDER BY CASE b.book_id")
+	for rank, id := range ordered {
+		clause.WriteString(" WHEN ? THEN ?")
+		args = append(args, id, rank)
+	}
+	clause.WriteString(" END")
+	return clause.String(), args
+}
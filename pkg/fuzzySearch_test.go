@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOSADistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"tolkein", "tolkien", 1},        // transposition
+		{"dostoyevsky", "dostoevsky", 1}, // extra letter
+		{"kitten", "sitting", 3},
+		{"ca", "abc", 3}, // OSA does not edit a transposed pair again
+		{"herbert", "herbert", 0},
+	}
+	for _, test := range tests {
+		if got := osaDistance([]rune(test.a), []rune(test.b)); got != test.want {
+			t.Errorf("osaDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestRankFuzzyMatches(t *testing.T) {
+	library := []fuzzyDocument{
+		{BookID: 1, Title: "The Hobbit", Authors: "J.R.R. Tolkien"},
+		{BookID: 2, Title: "Crime and Punishment", Authors: "Fyodor Dostoevsky"},
+		{BookID: 3, Title: "The Brothers Karamazov", Authors: "Fyodor Dostoevsky"},
+		{BookID: 4, Title: "Dune", Authors: "Frank Herbert"},
+		{BookID: 5, Title: "The Silmarillion", Authors: "J.R.R. Tolkien, Christopher Tolkien"},
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"Tolkein", []int{1, 5}},
+		{"Dostoyevsky", []int{2, 3}},
+		{"dostoyevsky karamazow", []int{3}},
+		{"author:tolkein hobit", []int{1}},
+		{"title:tolkein", nil},      // Tolkien is not in any title
+		{"Hobbit", []int{1}},        // exact words match with distance 0
+		{"hobb", []int{1}},          // prefixes match like in the full-text search
+		{"Dne", nil},                // short words must be exact
+		{"Crimson Punishment", nil}, // every word must match
+		{"silmarilion tolkein", []int{5}},
+	}
+
+	for _, test := range tests {
+		query, err := parseLibraryQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, match := range rankFuzzyMatches(library, query.fuzzyTerms()) {
+			got = append(got, match.BookID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("rankFuzzyMatches(%q) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestRankFuzzyMatchesOrder(t *testing.T) {
+	library := []fuzzyDocument{
+		{BookID: 1, Title: "Dostoevsky: A Writer in His Time"},
+		{BookID: 2, Title: "Dostoyevsky Reader"},
+	}
+	query, _ := parseLibraryQuery("dostoyevsky")
+	matches := rankFuzzyMatches(library, query.fuzzyTerms())
+	if len(matches) != 2 || matches[0].BookID != 2 || matches[0].Distance != 0 || matches[1].Distance != 1 {
+		t.Errorf("matches = %+v, want the exact spelling first", matches)
+	}
+}
+
+func TestFuzzyRankClause(t *testing.T) {
+	clause, args := fuzzyRankClause([]int{7, 3})
+	if clause != " ORDER BY CASE b.book_id WHEN ? THEN ? WHEN ? THEN ? END" {
+		t.Errorf("clause = %q", clause)
+	}
+	if want := []interface{}{7, 0, 3, 1}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
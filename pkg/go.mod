@@ -2,11 +2,13 @@ module hardcover
 
 go 1.23.4
 
-require github.com/mattn/go-sqlite3 v1.14.24
+require (
+	github.com/mattn/go-sqlite3 v1.14.24
+	golang.org/x/text v0.21.0
+)
 
 require (
 	golang.org/x/mod v0.22.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/tools v0.29.0 // indirect
 )
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Merged search: the library and the Hardcover catalog in one list. Library
+// hits are shown at once while a background process (-prefetch) runs the
+// catalog search into the search cache; Alfred reruns the script filter
+// until the catalog results are there, then both lists are merged.
+
+const (
+	mergedRerun       = 0.3              // seconds between reruns while waiting
+	mergedWaitTimeout = 15 * time.Second // then the library hits are shown alone
+	mergedRankOffset  = 10               // k of the reciprocal rank fusion
+)
+
+// mergedBook is a book of the merged list
+type mergedBook struct {
+	BookID      int
+	LocalRank   int // position in the library hits, -1 when absent
+	CatalogRank int // position in the catalog hits, -1 when absent
+	Score       float64
+}
+
+// mergeRankings fuses the library and catalog rankings: each list adds
+// 1/(k+rank) to the score of its books, so a book found by both rises.
+// Library books come first on equal scores.
+func mergeRankings(localIDs []int, catalogIDs []int) []mergedBook {
+	books := make(map[int]*mergedBook)
+	var order []int
+	entry := func(id int) *mergedBook {
+		if books[id] == nil {
+			books[id] = &mergedBook{BookID: id, LocalRank: -1, CatalogRank: -1}
+			order = append(order, id)
+		}
+		return books[id]
+	}
+	for rank, id := range localIDs {
+		if book := entry(id); book.LocalRank < 0 {
+			book.LocalRank = rank
+			book.Score += 1 / float64(mergedRankOffset+rank+1)
+		}
+	}
+	for rank, id := range catalogIDs {
+		if book := entry(id); book.CatalogRank < 0 {
+			book.CatalogRank = rank
+			book.Score += 1 / float64(mergedRankOffset+rank+1)
+		}
+	}
+
+	merged := make([]mergedBook, 0, len(order))
+	for _, id := range order {
+		merged = append(merged, *books[id])
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Score != merged[j].Score {
+			return merged[i].Score > merged[j].Score
+		}
+		return merged[i].LocalRank >= 0 && merged[j].LocalRank < 0
+	})
+	return merged
+}
+
+// parseMergedSearch parses a merged search the way the library search does
+func parseMergedSearch(searchString string) (libraryQuery, error) {
+	searchString, _, err := extractSortFlags(searchString)
+	if err != nil {
+		return libraryQuery{}, err
+	}
+	return parseLibraryQuery(searchString)
+}
+
+// mergedCatalogQuery is what the catalog is searched for: the words of the
+// text, title and author terms of a library search
+func mergedCatalogQuery(parsedQuery libraryQuery) string {
+	var words []string
+	for _, term := range parsedQuery.fuzzyTerms() {
+		words = append(words, term.Value)
+	}
+	return strings.Join(words, " ")
+}
+
+// hasWords reports whether the words of value start words of text, in order
+// when quoted
+func hasWords(text, value string, quoted bool) bool {
+	text, value = normalizeSearchText(text), normalizeSearchText(value)
+	if value == "" {
+		return true
+	}
+	if quoted {
+		return strings.HasPrefix(text, value) || strings.Contains(text, " "+value)
+	}
+	textWords := strings.Fields(text)
+	for _, word := range strings.Fields(value) {
+		found := false
+		for _, textWord := range textWords {
+			if strings.HasPrefix(textWord, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// catalogHitMatches applies the filters of a library search to a catalog
+// book that is not in the library: it has no status, shelf or rating of
+// mine. Plain text is left to the catalog, which searched for it.
+func catalogHitMatches(book BookSearch, parsedQuery libraryQuery) bool {
+	for _, term := range parsedQuery.Terms {
+		var matches bool
+		switch term.Field {
+		case fieldText:
+			if !term.Negated {
+				continue
+			}
+			matches = hasWords(book.Title+" "+book.Authors, term.Value, term.Quoted)
+		case fieldTitle:
+			matches = hasWords(book.Title, term.Value, term.Quoted)
+		case fieldAuthor:
+			matches = hasWords(book.Authors, term.Value, term.Quoted)
+		case fieldYear:
+			matches = meetsConditions(float64(book.ReleaseYear), term.Conditions)
+		case fieldCommunity:
+			matches = meetsConditions(book.Rating, term.Conditions)
+		case fieldRating:
+			// unrated, as the library counts it
+			matches = meetsConditions(0, term.Conditions)
+		case fieldStatus, fieldShelf, fieldShelfTag, fieldISBN:
+			// not in the library, or not known for catalog hits
+			matches = false
+		}
+		if matches == term.Negated {
+			return false
+		}
+	}
+	return true
+}
+
+// meetsConditions reports whether value passes every comparison
+func meetsConditions(value float64, conditions []numericCondition) bool {
+	for _, condition := range conditions {
+		var ok bool
+		switch condition.Op {
+		case "<":
+			ok = value < condition.Value
+		case "<=":
+			ok = value <= condition.Value
+		case ">":
+			ok = value > condition.Value
+		case ">=":
+			ok = value >= condition.Value
+		default:
+			ok = value == condition.Value
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// startCatalogPrefetch runs the catalog search in a process of its own, so
+// it outlives this invocation
+func startCatalogPrefetch(query string) error {
+	command := exec.Command(os.Args[0], "-prefetch", query)
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("failed to start the catalog search: %w", err)
+	}
+	return command.Process.Release()
+}
+
+// prefetchCatalog stores the first page of a catalog search in the search
+// cache (-prefetch)
+func prefetchCatalog(query string) {
+	books, err := searchCatalog(query)
+	if err != nil {
+		LogF("Catalog prefetch failed: %v", err)
+		return
+	}
+	fetchCatalogCovers(books)
+
+	cache, err := openSearchCache(searchCachePath)
+	if err != nil {
+		LogF("Search cache unavailable: %v", err)
+		return
+	}
+	defer cache.Close()
+	cache.Put(searchCacheKey(query), 1, "", books, time.Now())
+}
+
+var itemPosition = regexp.MustCompile(`^\d+/\d+,? `)
+
+// setItemPosition replaces the "n/total" at the start of a subtitle
+func setItemPosition(item map[string]interface{}, position string) {
+	if subtitle, ok := item["subtitle"].(string); ok {
+		item["subtitle"] = position + " " + itemPosition.ReplaceAllString(subtitle, "")
+	}
+}
+
+// searchMerged searches the library and the catalog at once (-merged)
+func searchMerged(searchString string) {
+	result, localIDs, err := libraryResult(searchString)
+	if result == nil {
+		// an error or a picker was served instead
+		if err != nil {
+			LogF("Library search failed: %v", err)
+		}
+		return
+	}
+	localItems := make(map[int]map[string]interface{})
+	for i, id := range localIDs {
+		if id != 0 {
+			localItems[id] = result["items"][i]
+		}
+	}
+
+	output := map[string]interface{}{}
+	items := []map[string]interface{}{}
+	parsedQuery, err := parseMergedSearch(searchString)
+	if err != nil {
+		LogF("Library search failed: %v", err)
+		return
+	}
+	query := mergedCatalogQuery(parsedQuery)
+
+	var catalogBooks []BookSearch
+	waiting := false
+	if query != "" {
+		cache, err := openSearchCache(searchCachePath)
+		if err != nil {
+			LogF("Search cache unavailable: %v", err)
+		}
+		cached := false
+		catalogBooks, cached = cache.Get(searchCacheKey(query), 1, "", false)
+		cache.Close()
+
+		if !cached {
+			// the prefetch started by an earlier run of the same search
+			started, _ := strconv.ParseInt(os.Getenv("mergedStarted"), 10, 64)
+			pending := os.Getenv("mergedQuery") == query
+			switch {
+			case pending && time.Since(time.Unix(started, 0)) > mergedWaitTimeout:
+				items = append(items, map[string]interface{}{
+					"title":    "Hardcover did not answer",
+					"subtitle": "Showing your library only",
+					"valid":    false,
+					"icon": map[string]string{
+						"path": "icons/hopeless.png",
+					},
+				})
+			case pending:
+				waiting = true
+			default:
+				if err := startCatalogPrefetch(query); err != nil {
+					LogF("%v", err)
+					break
+				}
+				started = time.Now().Unix()
+				waiting = true
+			}
+			if waiting {
+				output["rerun"] = mergedRerun
+				output["variables"] = map[string]interface{}{
+					"mergedQuery":   query,
+					"mergedStarted": strconv.FormatInt(started, 10),
+				}
+			}
+		}
+	}
+
+	bookStatusMap := map[int]BookInfoMap{}
+	if len(catalogBooks) > 0 {
+		bookStatusMap, err = fetchBookIDs()
+		if err != nil {
+			LogF("Error fetching book IDs: %v", err)
+		}
+	}
+	var catalogIDs []int
+	catalogByID := make(map[int]BookSearch)
+	for _, book := range catalogBooks {
+		if _, inLibrary := bookStatusMap[book.ID]; inLibrary {
+			// the library search already judged it against every filter
+			if localItems[book.ID] == nil {
+				continue
+			}
+		} else if !catalogHitMatches(book, parsedQuery) {
+			continue
+		}
+		catalogIDs = append(catalogIDs, book.ID)
+		catalogByID[book.ID] = book
+	}
+	var orderedLocal []int
+	for _, id := range localIDs {
+		if id != 0 {
+			orderedLocal = append(orderedLocal, id)
+		}
+	}
+	merged := mergeRankings(orderedLocal, catalogIDs)
+
+	for i, book := range merged {
+		position := fmt.Sprintf("%d/%d", i+1, len(merged))
+		// the library copy of a book wins
+		item, inLibrary := localItems[book.BookID]
+		if !inLibrary {
+			item = catalogBookItem(catalogByID[book.BookID], position, bookStatusMap)
+		}
+		setItemPosition(item, position)
+		items = append(items, item)
+	}
+
+	if waiting {
+		items = append(items, map[string]interface{}{
+			"title":    "Searching Hardcover…",
+			"subtitle": query,
+			"valid":    false,
+			"icon": map[string]string{
+				"path": "icons/bookPile.png",
+			},
+		})
+	}
+	if len(items) == 0 {
+		items = append(items, map[string]interface{}{
+			"title":    "no results here 🙂",
+			"subtitle": "try something else",
+			"valid":    false,
+			"icon": map[string]string{
+				"path": "icons/hopeless.png",
+			},
+		})
+	}
+	output["items"] = items
+
+	jsonData, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		LogF("Error encoding JSON: %v", err)
+		return
+	}
+	fmt.Println(string(jsonData))
+}
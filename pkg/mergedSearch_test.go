@@ -0,0 +1,99 @@
+package main
+
+import "testing"
+
+func TestMergeRankings(t *testing.T) {
+	merged := mergeRankings([]int{1, 2, 3}, []int{3, 4, 1, 5})
+	var got []int
+	for _, book := range merged {
+		got = append(got, book.BookID)
+	}
+	// 1 and 3 are in both lists and tie, keeping the library order; 2 and 4
+	// are second in their lists, the library one first
+	want := []int{1, 3, 2, 4, 5}
+	if len(got) != len(want) {
+		t.Fatalf("mergeRankings = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("mergeRankings = %v, want %v", got, want)
+		}
+	}
+	if merged[1].LocalRank != 2 || merged[1].CatalogRank != 0 {
+		t.Errorf("book 3 ranks = %d, %d; want 2, 0", merged[1].LocalRank, merged[1].CatalogRank)
+	}
+	if merged[4].LocalRank != -1 {
+		t.Errorf("catalog-only book has library rank %d", merged[4].LocalRank)
+	}
+}
+
+func TestMergeRankingsOneSide(t *testing.T) {
+	merged := mergeRankings([]int{7, 8}, []int{9})
+	if merged[0].BookID != 7 || merged[1].BookID != 9 {
+		t.Errorf("library first on equal scores: %+v", merged)
+	}
+	merged = mergeRankings([]int{7, 8}, nil)
+	if len(merged) != 2 || merged[0].BookID != 7 || merged[1].BookID != 8 {
+		t.Errorf("library order not kept: %+v", merged)
+	}
+}
+
+func TestMergedCatalogQuery(t *testing.T) {
+	parsedQuery, err := parseMergedSearch(`author:tolkien "two towers" @reading year:>1950 -hobbit --y`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mergedCatalogQuery(parsedQuery); got != "tolkien two towers" {
+		t.Errorf("mergedCatalogQuery = %q, want %q", got, "tolkien two towers")
+	}
+}
+
+func TestCatalogHitMatches(t *testing.T) {
+	towers := BookSearch{Title: "The Two Towers", Authors: "J.R.R. Tolkien", ReleaseYear: 1954, Rating: 4.4}
+	hobbit := BookSearch{Title: "The Hobbit", Authors: "J.R.R. Tolkien", ReleaseYear: 1937, Rating: 4.3}
+	tests := []struct {
+		search string
+		book   BookSearch
+		want   bool
+	}{
+		{"tolkien", towers, true},
+		{"tolkien -hobbit", hobbit, false},
+		{"tolkien -hobbit", towers, true},
+		{`-"two towers"`, towers, false},
+		{"author:tolkien", towers, true},
+		{"author:towers", towers, false},
+		{"title:tow", towers, true},
+		{"tolkien year:>1950", hobbit, false},
+		{"tolkien year:>1950", towers, true},
+		{"tolkien community:>=4.4", hobbit, false},
+		{"tolkien @reading", towers, false},
+		{"tolkien -@reading", towers, true},
+		{"tolkien shelf:fantasy", towers, false},
+		{"tolkien -shelf:fantasy", towers, true},
+		{"tolkien rating:>3", towers, false},
+		{"tolkien rating:<3", towers, true},
+		{"tolkien isbn:9780261102361", towers, false},
+	}
+	for _, test := range tests {
+		parsedQuery, err := parseMergedSearch(test.search)
+		if err != nil {
+			t.Fatalf("%q: %v", test.search, err)
+		}
+		if got := catalogHitMatches(test.book, parsedQuery); got != test.want {
+			t.Errorf("catalogHitMatches(%q, %q) = %v, want %v", test.search, test.book.Title, got, test.want)
+		}
+	}
+}
+
+func TestSetItemPosition(t *testing.T) {
+	item := map[string]interface{}{"subtitle": "3/12 Tolkien, 1954"}
+	setItemPosition(item, "1/20")
+	if item["subtitle"] != "1/20 Tolkien, 1954" {
+		t.Errorf("library subtitle = %q", item["subtitle"])
+	}
+	item = map[string]interface{}{"subtitle": "1/20, Tolkien (1954) ★"}
+	setItemPosition(item, "5/20")
+	if item["subtitle"] != "5/20 Tolkien (1954) ★" {
+		t.Errorf("catalog subtitle = %q", item["subtitle"])
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The schema of books.db is owned by the migrations below. The version
+// applied last is stored in PRAGMA user_version; opening a database applies
+// the missing migrations in order. Never edit a released migration: append
+// a new one instead.
+
+type migration struct {
+	version     int
+	description string
+	statements  []string
+	// backfill, when set, fills new columns after the statements ran
+	backfill func(tx *sql.Tx) error
+	// resync, when set, fills new columns with the next sync: migrating an
+	// existing library makes it fetch the whole library again
+	resync bool
+}
+
+var migrations = []migration{
+	{
+		version:     1,
+		description: "library tables, ratings and full-text index",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS books (
+			book_id INTEGER PRIMARY KEY,
+			user_book_id INTEGER UNIQUE,
+			user_rating REAL,
+			status_id INTEGER,
+			title TEXT,
+			rating REAL,
+			ratings_count INTEGER,
+			release_year INTEGER,
+			image_url TEXT,
+			cover_file TEXT,
+			isbn_10 TEXT,
+			isbn_13 TEXT,
+			slug TEXT,
+			shelves TEXT
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_books_status ON books(status_id)`,
+
+			`CREATE TABLE IF NOT EXISTS journey (
+			journey_id INTEGER PRIMARY KEY,
+			user_book_id INTEGER,
+			started_at TEXT,
+			finished_at TEXT,
+			FOREIGN KEY(user_book_id) REFERENCES books(user_book_id)
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS author (
+			ID INTEGER PRIMARY KEY AUTOINCREMENT,
+			book_id INTEGER,
+			name TEXT,
+			contribution TEXT,
+			FOREIGN KEY(book_id) REFERENCES books(book_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_author_book ON author(book_id)`,
+
+			`CREATE TABLE IF NOT EXISTS shelf (
+			ID INTEGER PRIMARY KEY AUTOINCREMENT,
+			shelf_id INTEGER,
+			user_book_id INTEGER,
+			list_book_id INTEGER,
+			name TEXT,
+			FOREIGN KEY(user_book_id) REFERENCES books(user_book_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_shelf_userbook ON shelf(user_book_id)`,
+
+			`CREATE TABLE IF NOT EXISTS bookshelves (
+			shelf_id INTEGER PRIMARY KEY,
+			name TEXT,
+			books_count INTEGER,
+			public BOOLEAN,
+			slug TEXT,
+			FOREIGN KEY(shelf_id) REFERENCES shelf(shelf_id)
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS ratings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rating REAL,
+			count INTEGER
+			)`,
+
+			`CREATE VIRTUAL TABLE IF NOT EXISTS books_authors_fts USING fts5(
+			book_id UNINDEXED,  -- Book ID (not used for searching)
+			title,              -- Book title (searchable)
+			authors             -- Concatenated author names (searchable)
+			)`,
+		},
+	},
+	{
+		version:     2,
+		description: "date added and author sort name",
+		statements: []string{
+			// date_added is filled by the full sync that follows
+			`ALTER TABLE books ADD COLUMN date_added TEXT`,
+			`ALTER TABLE books ADD COLUMN author_sort TEXT`,
+		},
+		backfill: backfillAuthorSort,
+		resync:   true,
+	},
+	{
+		version:     3,
+		description: "diacritic- and punctuation-insensitive full-text index",
+		statements: []string{
+			`DROP TABLE IF EXISTS books_authors_fts`,
+			// title and authors hold normalize_search_text output, the
+			// tokenizer folds whatever diacritics are left
+			`CREATE VIRTUAL TABLE books_authors_fts USING fts5(
+			book_id UNINDEXED,
+			title,
+			authors,
+			authors_display UNINDEXED,  -- author names as shown
+			tokenize = 'unicode61 remove_diacritics 2'
+			)`,
+		},
+		backfill: func(tx *sql.Tx) error {
+			return createFTSTables(tx)
+		},
+	},
+	{
+		version:     4,
+		description: "book descriptions",
+		statements: []string{
+			// filled by the full sync that follows
+			`ALTER TABLE books ADD COLUMN description TEXT`,
+		},
+		resync: true,
+	},
+	{
+		version:     5,
+		description: "reading progress and book length",
+		statements: []string{
+			// filled by the full sync that follows
+			`ALTER TABLE journey ADD COLUMN progress_pages INTEGER`,
+			`ALTER TABLE journey ADD COLUMN progress_seconds INTEGER`,
+			`ALTER TABLE books ADD COLUMN pages INTEGER`,
+			`ALTER TABLE books ADD COLUMN audio_seconds INTEGER`,
+		},
+		resync: true,
+	},
+}
+
+// latestSchemaVersion is the schema version this build writes
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaTooNewError is returned for a database written by a newer build
+type SchemaTooNewError struct {
+	Found     int
+	Supported int
+}
+
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("books.db uses schema version %d, but this version of the workflow only knows up to %d: update the workflow, or run ::hardcover-refresh to rebuild the library",
+		e.Found, e.Supported)
+}
+
+// openLibraryDatabase opens a library database, creating it if needed, and
+// brings its schema up to date
+func openLibraryDatabase(dbPath string) (*sql.DB, error) {
+	// immediate transactions and a busy timeout let concurrent invocations
+	// wait for each other instead of failing
+	db, err := sql.Open(libraryDriverName, dbPath+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	if err := migrateDatabase(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// schemaVersion reads the version stored in the database
+func schemaVersion(db sqlExecutor) (int, error) {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrateDatabase applies the missing migrations, each in its own
+// transaction, and refuses databases newer than this build
+func migrateDatabase(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > latestSchemaVersion() {
+		return &SchemaTooNewError{Found: version, Supported: latestSchemaVersion()}
+	}
+
+	resync := false
+	defer func() {
+		// a new library has nothing to fill
+		if resync && version > 0 {
+			requestFullSync()
+		}
+	}()
+
+	for _, step := range migrations {
+		if step.version <= version {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", step.version, err)
+		}
+
+		// another invocation may have migrated while we waited for the lock
+		current, err := schemaVersion(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if current >= step.version {
+			tx.Rollback()
+			continue
+		}
+
+		for _, statement := range step.statements {
+			if _, err := tx.Exec(statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d (%s) failed: %w", step.version, step.description, err)
+			}
+		}
+		if step.backfill != nil {
+			if err := step.backfill(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d (%s) failed: %w", step.version, step.description, err)
+			}
+		}
+		// PRAGMA does not take parameters; the version is an integer constant
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, step.version)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record schema version %d: %w", step.version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", step.version, err)
+		}
+		LogF("Database migrated to schema version %d (%s)", step.version, step.description)
+		resync = resync || step.resync
+	}
+	return nil
+}
+
+// backfillAuthorSort sets books.author_sort from the first stored author
+func backfillAuthorSort(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT a.book_id, a.name FROM author a
+		WHERE a.ID = (SELECT MIN(ID) FROM author WHERE book_id = a.book_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to read authors: %w", err)
+	}
+	surnames := make(map[int]string)
+	for rows.Next() {
+		var bookID int
+		var name string
+		if err := rows.Scan(&bookID, &name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan author: %w", err)
+		}
+		surnames[bookID] = authorSurname(name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for bookID, surname := range surnames {
+		if _, err := tx.Exec(`UPDATE books SET author_sort = ? WHERE book_id = ?`, surname, bookID); err != nil {
+			return fmt.Errorf("failed to store author sort name: %w", err)
+		}
+	}
+	return nil
+}
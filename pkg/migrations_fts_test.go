@@ -0,0 +1,51 @@
+//go:build sqlite_fts5 || fts5
+
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrationRequestsFullSync checks that upgrading a library asks the
+// next sync to fetch everything, while a new library does not
+func TestMigrationRequestsFullSync(t *testing.T) {
+	saved := dataFolder
+	dataFolder = t.TempDir()
+	t.Cleanup(func() { dataFolder = saved })
+
+	db, err := openLibraryDatabase(filepath.Join(dataFolder, "new.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if fullSyncPending() {
+		t.Error("a new library requested a full sync")
+	}
+
+	oldPath := filepath.Join(dataFolder, "old.db")
+	old, err := sql.Open(libraryDriverName, oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range append(migrations[0].statements, `PRAGMA user_version = 1`) {
+		if _, err := old.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err = openLibraryDatabase(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if !fullSyncPending() {
+		t.Error("migrating a library did not request a full sync")
+	}
+	clearFullSync()
+	if fullSyncPending() {
+		t.Error("full sync request not cleared")
+	}
+}
@@ -1,9 +1,20 @@
 package main
 
 import (
+	"database/sql"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx, so the derived
+// tables can be rebuilt inside or outside a transaction
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
 // GraphQLRequest defines the structure of a GraphQL query
 type GraphQLRequest struct {
 	Query     string                 `json:"query"`
@@ -12,8 +23,17 @@ type GraphQLRequest struct {
 
 // GraphQLResponse captures the response from the API
 type GraphQLResponse struct {
-	Data   interface{} `json:"data"`
-	Errors interface{} `json:"errors"`
+	Data   interface{}    `json:"data"`
+	Errors []GraphQLError `json:"errors"`
+}
+
+// GraphQLError is one entry of the `errors` array of a GraphQL response
+type GraphQLError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		Code string `json:"code"`
+		Path string `json:"path"`
+	} `json:"extensions"`
 }
 
 type BookSearch struct {
@@ -27,12 +47,20 @@ type BookSearch struct {
 	ID          int     `json:"id"`
 	ReleaseYear int     `json:"release_year"`
 	Slug        string  `json:"slug"`
+	// MatchedBy names the ISBN or URL the book was looked up by
+	MatchedBy string `json:"matched_by,omitempty"`
+	// SeriesPosition is set when listing a series ("1", "2.5")
+	SeriesPosition string `json:"series_position,omitempty"`
 }
 
 type BookInfoMap struct {
 	StatusID   int
 	UserRating float64
 	Shelves    string
+	ShelfCount int // distinct shelves, as the library sort counts them
+	DateAdded  string
+	StartedAt  string
+	FinishedAt string
 }
 
 type GraphQLResponseSearch struct {
@@ -64,6 +92,22 @@ type GraphQLResponseSearch struct {
 	} `json:"data"`
 }
 
+// APIEditionLookup is the response of editionByISBNQuery
+type APIEditionLookup struct {
+	Data struct {
+		Editions []struct {
+			Book Book `json:"book"`
+		} `json:"editions"`
+	} `json:"data"`
+}
+
+// APIBookLookup is the response of bookBySlugQuery
+type APIBookLookup struct {
+	Data struct {
+		Books []Book `json:"books"`
+	} `json:"data"`
+}
+
 type ShelfJSON struct {
 	Data struct {
 		Me []struct {
@@ -117,11 +161,6 @@ var RatingEmoji = map[float64]string{
 	4.5: "⭐️⭐️⭐️⭐️✨️",
 	5:   "⭐️⭐️⭐️⭐️⭐️",
 }
-var orderClauses = map[string]string{
-	"--y": " ORDER BY b.release_year DESC",
-	"--r": " ORDER BY b.rating DESC",
-	"--t": " ORDER BY b.title ASC",
-}
 var ReadStatusEmoji = map[int]string{
 	1: "📚️",
 	2: "📖",
@@ -142,6 +181,7 @@ type UserBook struct {
 	UserBookReads []UserBookRead `json:"user_book_reads"`
 	Book          Book           `json:"book"`
 	Edition       Edition        `json:"edition"`
+	DateAdded     string         `json:"date_added"`
 }
 
 type Book struct {
@@ -151,6 +191,9 @@ type Book struct {
 	ReleaseYear        int                `json:"release_year"`
 	RatingsCount       int                `json:"ratings_count"`
 	Slug               string             `json:"slug"`
+	Description        string             `json:"description"`
+	Pages              int                `json:"pages"`
+	AudioSeconds       int                `json:"audio_seconds"`
 	CachedImage        CachedImage        `json:"cached_image"`
 	CachedContributors []ContributorEntry `json:"cached_contributors"`
 }
@@ -176,52 +219,37 @@ type Author struct {
 }
 
 type UserBookRead struct {
-	ID         int     `json:"id"`
-	StartedAt  *string `json:"started_at"`
-	FinishedAt *string `json:"finished_at"`
+	ID              int     `json:"id"`
+	UserBookID      int     `json:"user_book_id"`
+	StartedAt       *string `json:"started_at"`
+	FinishedAt      *string `json:"finished_at"`
+	ProgressPages   *int    `json:"progress_pages"`
+	ProgressSeconds *int    `json:"progress_seconds"`
 }
 
 type Edition struct {
-	ISBN10 *string `json:"isbn_10"`
-	ISBN13 *string `json:"isbn_13"`
+	ISBN10       *string `json:"isbn_10"`
+	ISBN13       *string `json:"isbn_13"`
+	Pages        int     `json:"pages"`
+	AudioSeconds int     `json:"audio_seconds"`
 }
 
-type APIshelf struct {
+type APIListBooks struct {
 	Data struct {
-		Lists []struct {
-			ID         int    `json:"id"`
-			Name       string `json:"name"`
-			BooksCount int    `json:"books_count"`
-			ListBooks  []struct {
-				ID     int `json:"id"`
-				BookID int `json:"book_id"`
-				Book   struct {
-					Title              string      `json:"title"`
-					ReleaseYear        int         `json:"release_year"`
-					CachedImage        CachedImage `json:"cached_image"`
-					Rating             float64     `json:"rating"`
-					Slug               string      `json:"slug"`
-					RatingsCount       int         `json:"ratings_count"`
-					CachedContributors []struct {
-						Author struct {
-							Slug        string `json:"slug"`
-							Name        string `json:"name"`
-							CachedImage struct {
-								ID        int    `json:"id"`
-								URL       string `json:"url"`
-								Color     string `json:"color"`
-								Width     int    `json:"width"`
-								Height    int    `json:"height"`
-								ColorName string `json:"color_name"`
-							} `json:"cachedImage"`
-						} `json:"author"`
-						Contribution *string `json:"contribution"` // Nullable field
-					} `json:"cached_contributors"`
-				} `json:"book"`
-				UserBooks []struct {
-					ID int `json:"id"`
-				} `json:"user_books"`
-			} `json:"list_books"`
-		} `json:"lists"`
+		ListBooks []ListBook `json:"list_books"`
 	} `json:"data"`
 }
+
+type ListBook struct {
+	ID     int `json:"id"`
+	BookID int `json:"book_id"`
+	List   struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"list"`
+	Book      Book   `json:"book"`
+	DateAdded string `json:"date_added"`
+	UserBooks []struct {
+		ID int `json:"id"`
+	} `json:"user_books"`
+}
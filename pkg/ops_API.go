@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// APIError describes a request the Hardcover API did not carry out
+type APIError struct {
+	StatusCode int      // HTTP status, 0 when the failure was reported in the body
+	Messages   []string // messages from the GraphQL `errors` array or the mutation `error` field
+}
+
+func (e *APIError) Error() string {
+	message := strings.Join(e.Messages, "; ")
+	if e.StatusCode != 0 {
+		if message == "" {
+			message = http.StatusText(e.StatusCode)
+		}
+		return fmt.Sprintf("Hardcover API error (HTTP %d): %s", e.StatusCode, message)
+	}
+	return "Hardcover API error: " + message
+}
+
+// apiClient is shared by all API calls; the timeout keeps Alfred from hanging
+var apiClient = &http.Client{Timeout: apiTimeout}
+
+// MutationResult is the payload returned by the user_book mutations
+type MutationResult struct {
+	ID       *int    `json:"id"`
+	Error    *string `json:"error"`
+	UserBook *struct {
+		ID       int      `json:"id"`
+		StatusID int      `json:"status_id"`
+		Rating   *float64 `json:"rating"`
+		Book     struct {
+			ID int `json:"id"`
+		} `json:"book"`
+	} `json:"user_book"`
+	UserBookRead *UserBookRead `json:"user_book_read"`
+}
+
+// interrogateAPI sends a query; failed attempts are retried
+func interrogateAPI(requestBody GraphQLRequest) ([]byte, error) {
+	return sendGraphQL(requestBody, true)
+}
+
+// sendGraphQL sends a request. Queries (idempotent) are retried after any
+// transient failure; a mutation only when the server surely did not run it:
+// HTTP 429, or a connection that failed before the request went out.
+// Retrying a mutation whose answer was lost would apply it twice.
+func sendGraphQL(requestBody GraphQLRequest, idempotent bool) ([]byte, error) {
+
+	// Start timing
+	startTime := time.Now()
+
+	if authToken == "" {
+		err := fmt.Errorf("error: Authorization token is empty")
+		fmt.Fprintln(os.Stderr, err) // Print error to stderr
+		return nil, err
+	}
+
+	payload, err := json.Marshal(requestBody)
+	if err != nil {
+		err = fmt.Errorf("error encoding JSON: %w", err)
+		fmt.Fprintln(os.Stderr, err) // Print error to stderr
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		// every attempt counts against the shared per-minute budget
+		if err := reserveAPIRequest(); err != nil {
+			fmt.Fprintln(os.Stderr, err) // Print error to stderr
+			return nil, err
+		}
+
+		statusCode, header, body, err := postGraphQL(payload)
+		if err != nil {
+			if attempt < apiMaxRetries && (idempotent || requestNotSent(err)) {
+				wait := apiBaseBackoff << attempt
+				LogF("%v, retrying in %v", err, wait)
+				time.Sleep(wait)
+				continue
+			}
+			fmt.Fprintln(os.Stderr, err) // Print error to stderr
+			return nil, err
+		}
+
+		if isRetryableStatus(statusCode) && (idempotent || statusCode == http.StatusTooManyRequests) {
+			wait := retryDelay(header, attempt)
+			if statusCode == http.StatusTooManyRequests {
+				// let the other invocations know the server wants a pause
+				blockAPIRequests(time.Now().Add(wait))
+			}
+			if attempt < apiMaxRetries && wait <= apiMaxRetryWait {
+				LogF("API answered HTTP %d, retrying in %v", statusCode, wait)
+				time.Sleep(wait)
+				continue
+			}
+			if statusCode == http.StatusTooManyRequests {
+				err := &BudgetError{Limit: apiBudgetPerMinute, Wait: wait}
+				fmt.Fprintln(os.Stderr, err) // Print error to stderr
+				return nil, err
+			}
+		}
+
+		// Log the execution time
+		elapsedTime := time.Since(startTime)
+		LogF("Execution time (interrogateAPI): %d ms", elapsedTime.Milliseconds())
+
+		if err := checkResponse(statusCode, body); err != nil {
+			fmt.Fprintln(os.Stderr, err) // Print error to stderr
+			return nil, err
+		}
+
+		return body, nil
+	}
+}
+
+// postGraphQL sends one request to the API and returns the raw answer
+func postGraphQL(payload []byte) (int, http.Header, []byte, error) {
+	req, err := http.NewRequest("POST", apiRoot, bytes.NewBuffer(payload))
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+
+	// Set required headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authToken)
+
+	// Execute the HTTP request
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("error making HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	return resp.StatusCode, resp.Header, body, nil
+}
+
+// checkResponse turns non-2xx statuses and GraphQL `errors` into an APIError
+func checkResponse(statusCode int, body []byte) error {
+	var envelope GraphQLResponse
+	decodeErr := json.Unmarshal(body, &envelope)
+
+	var messages []string
+	for _, graphQLError := range envelope.Errors {
+		messages = append(messages, graphQLError.Message)
+	}
+
+	if statusCode < 200 || statusCode > 299 {
+		if len(messages) == 0 {
+			// not a GraphQL answer (proxy or gateway page): keep a short excerpt
+			excerpt := strings.TrimSpace(string(body))
+			if len(excerpt) > 120 {
+				excerpt = excerpt[:120] + "…"
+			}
+			if excerpt != "" {
+				messages = append(messages, excerpt)
+			}
+		}
+		return &APIError{StatusCode: statusCode, Messages: messages}
+	}
+
+	if len(messages) > 0 {
+		return &APIError{Messages: messages}
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("error decoding API response: %w", decodeErr)
+	}
+	return nil
+}
+
+// decodeMutation extracts the result of the named mutation from a response
+// body, turning a null result or its `error` field into a Go error
+func decodeMutation(body []byte, field string) (MutationResult, error) {
+	var result MutationResult
+
+	var envelope struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return result, fmt.Errorf("error decoding %s response: %w", field, err)
+	}
+
+	raw, exists := envelope.Data[field]
+	if !exists || string(raw) == "null" {
+		return result, &APIError{Messages: []string{field + " returned no result"}}
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return result, fmt.Errorf("error decoding %s response: %w", field, err)
+	}
+	if result.Error != nil && *result.Error != "" {
+		return result, &APIError{Messages: []string{*result.Error}}
+	}
+	return result, nil
+}
+
+// runMutation sends a mutation and checks the result of the named field
+func runMutation(request GraphQLRequest, field string) (MutationResult, error) {
+	body, err := sendGraphQL(request, false)
+	if err != nil {
+		return MutationResult{}, err
+	}
+	return decodeMutation(body, field)
+}
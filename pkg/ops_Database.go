@@ -1,18 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"path"
-	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -20,114 +12,6 @@ import (
 	"golang.org/x/text/message"
 )
 
-/*
-	func fetchImages(imageURLs []string) error {
-		// Get the system temporary directory
-		tempDir := os.TempDir()
-
-		for _, url := range imageURLs {
-			if url == "" { // Skip if the URL is empty
-				continue
-			}
-			// Get the file name from the URL
-			fileName := filepath.Base(url)
-			if strings.Contains(fileName, "?") {
-				// Remove query parameters if present
-				fileName = strings.Split(fileName, "?")[0]
-			}
-			filePath := filepath.Join(tempDir, fileName)
-
-			// Download the file
-			resp, err := http.Get(url)
-			if err != nil {
-				LogF("Failed to download %s: %v\n", url, err)
-				continue
-			}
-			defer resp.Body.Close()
-
-			// Check if the HTTP response status is OK
-			if resp.StatusCode != http.StatusOK {
-				fmt.Printf("Failed to download %s: status code %d\n", url, resp.StatusCode)
-				continue
-			}
-
-			// Create the file in the temp directory
-			file, err := os.Create(filePath)
-			if err != nil {
-				fmt.Printf("Failed to create file for %s: %v\n", url, err)
-				continue
-			}
-			defer file.Close()
-
-			// Save the file
-			_, err = io.Copy(file, resp.Body)
-			if err != nil {
-				fmt.Printf("Failed to save file for %s: %v\n", url, err)
-			} else {
-				// LogF("Image saved: %s\n", filePath)
-			}
-		}
-
-		return nil
-	}
-*/
-func fetchImages(imageURLs []string) error {
-	var wg sync.WaitGroup
-	tempDir := os.TempDir()
-	semaphore := make(chan struct{}, 5) // Limit to 5 concurrent downloads
-
-	for _, url := range imageURLs {
-		if url == "" {
-			continue
-		}
-
-		wg.Add(1)
-		go func(url string) {
-			defer wg.Done()
-			semaphore <- struct{}{}        // Acquire a slot
-			defer func() { <-semaphore }() // Release slot
-
-			// Get the file name
-			fileName := filepath.Base(url)
-			if strings.Contains(fileName, "?") {
-				fileName = strings.Split(fileName, "?")[0]
-			}
-			filePath := filepath.Join(tempDir, fileName)
-
-			// Download the file
-			resp, err := http.Get(url)
-			if err != nil {
-				LogF("Failed to download %s: %v\n", url, err)
-				return
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				LogF("Failed to download %s: status code %d\n", url, resp.StatusCode)
-				return
-			}
-
-			// Create the file
-			file, err := os.Create(filePath)
-			if err != nil {
-				LogF("Failed to create file for %s: %v\n", url, err)
-				return
-			}
-			defer file.Close()
-
-			// Save the file
-			_, err = io.Copy(file, resp.Body)
-			if err != nil {
-				LogF("Failed to save file for %s: %v\n", url, err)
-			}
-
-		}(url)
-	}
-
-	wg.Wait() // Wait for all downloads to complete
-	return nil
-}
-
 func extractBooks(responseBody []byte) ([]BookSearch, error) {
 	var graphqlResponse GraphQLResponseSearch
 	err := json.Unmarshal(responseBody, &graphqlResponse)
@@ -169,21 +53,6 @@ func extractBooks(responseBody []byte) ([]BookSearch, error) {
 	return books, nil
 }
 
-func loadCachedSearchResults() []BookSearch {
-	startTime := time.Now()
-	var book []BookSearch
-	err := json.Unmarshal([]byte(os.Getenv("BOOK_SEARCH")), &book)
-	if err != nil {
-		fmt.Println("Error unmarshalling:", err)
-		return book
-	}
-	LogF("Book list loaded from environment")
-	elapsedTime := time.Since(startTime)
-	LogF("Execution time loading cached search results: %d ms", elapsedTime.Milliseconds())
-	return book
-
-}
-
 func filterSearchQuery(query string) string {
 	// Split the query into words (tokens)
 	tokens := strings.Fields(query)
@@ -206,63 +75,202 @@ func filterSearchQuery(query string) string {
 	return currentDBsearch
 }
 
-func queryRemoteDatabase(searchString string) []BookSearch {
-	startTime := time.Now()
-
-	// Define the corrected GraphQL query, replacing "james monroe" with searchString
-	query := fmt.Sprintf(`{search(query: "%s", query_type: "Book", per_page: %d, page: 1) {
-		results  
-		  }}`, searchString, numberResults)
-
-	// Build the request payload
-	requestBody := GraphQLRequest{
-		Query: query,
-	}
-
-	payload, err := json.Marshal(requestBody)
-	if err != nil {
-		LogF("Error encoding JSON:", err)
-		return []BookSearch{}
-	}
-	// LogF("Request Payload:", string(payload))
+// searchRemote runs the catalog search for one kind of document
+func searchRemote(queryType string, searchString string, page int) ([]byte, error) {
+	// Build the request: the search string travels as a variable
+	request := newGraphQLRequest(catalogSearchQuery, graphQLVars{
+		"query":     searchString,
+		"queryType": queryType,
+		"perPage":   numberResults,
+		"page":      page,
+	})
 
-	// Make the HTTP request
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", apiRoot, bytes.NewBuffer(payload))
-	if err != nil {
-		LogF("Error creating HTTP request:", err)
-		return []BookSearch{}
-	}
-
-	// Set required headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", authToken)
-
-	// Execute the HTTP request
 	LogF("interrogating the API...")
-	resp, err := client.Do(req)
+	body, err := interrogateAPI(request)
 	if err != nil {
-		LogF("Error making HTTP request:", err)
-		return []BookSearch{}
+		LogF("Error querying the API: %v", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return body, nil
+}
+
+func queryRemoteDatabase(searchString string, page int) ([]BookSearch, error) {
+	startTime := time.Now()
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	// LogF("Response body:", string(body))
+	body, err := searchRemote("Book", searchString, page)
 	if err != nil {
-		LogF("Error reading response body:", err)
-		return []BookSearch{}
+		return []BookSearch{}, err
 	}
 	books, err := extractBooks(body) // `body` is the HTTP response body
 	if err != nil {
 		LogF("Error extracting books:", err)
-		return []BookSearch{}
+		return []BookSearch{}, err
 	}
 
 	elapsedTime := time.Since(startTime)
 	LogF("Execution time remote database search: %d ms", elapsedTime.Milliseconds())
-	return books
+	return books, nil
+}
+
+// fetchCatalogCovers downloads the missing covers into the shared cache
+func fetchCatalogCovers(books []BookSearch) {
+	var imageURLs []string
+	for _, book := range books {
+		if book.ImageURL != "" {
+			imageURLs = append(imageURLs, book.ImageURL)
+		}
+	}
+	covers.Fetch(imageURLs)
+}
+
+// fetchCatalogBooks fetches the books of a search or drill-down. Pages
+// cached under another sort, or fewer of them, are reused; only the missing
+// pages are requested. It returns when the oldest of the books was fetched.
+func fetchCatalogBooks(cache *searchCache, cacheKey string, searchString string, pages int, kind catalogKind, drillDownID int) ([]BookSearch, time.Time, error) {
+	books, cachedPages, fetchedAt := cache.Closest(cacheKey, pages)
+	if cachedPages == 0 {
+		var err error
+		if drillDownID != 0 {
+			books, err = drillDownBooks(kind, drillDownID)
+		} else {
+			// ISBNs and book URLs are looked up directly (see catalogLookup.go)
+			books, err = searchCatalog(searchString)
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		cachedPages, fetchedAt = 1, time.Now()
+	}
+	if pages > cachedPages {
+		var err error
+		books, err = fetchCatalogPages(books, searchString, cachedPages+1, pages)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return books, fetchedAt, nil
+}
+
+// catalogBookItem is the Alfred item of a catalog book; position is shown
+// first in the subtitle ("3/20"), bookStatusMap marks the library books
+func catalogBookItem(book BookSearch, position string, bookStatusMap map[int]BookInfoMap) map[string]interface{} {
+	var currentRating float64
+	// Format rating as string
+	ratingStr := fmt.Sprintf("%.2f", book.Rating)
+	// Check if book_id exists in the map and compare the status_id
+	var userLibrarySymbol string
+	readingStatusSubtitle := "Assign reading status"
+	ratingSubtitle := "Assign rating"
+	var shelfSubtitle string
+	var shelfSymbol string
+	if bookInfo, exists := bookStatusMap[book.ID]; exists {
+		readingStatusSubtitle = "Change reading status"
+		userLibrarySymbol = ReadStatusEmoji[bookInfo.StatusID]
+		switch bookInfo.StatusID {
+		case 1:
+			readingStatusSubtitle = "Change reading status (currently: to read)"
+		case 2:
+			readingStatusSubtitle = "Change reading status (currently: reading)"
+		case 3: // Multiple cases can share the same block
+			readingStatusSubtitle = "Change reading status (currently: read)"
+		case 4: // Multiple cases can share the same block
+			readingStatusSubtitle = "Change reading status (currently: DNF)"
+		default:
+			readingStatusSubtitle = "Assign reading status"
+		}
+		if bookInfo.UserRating > 0 {
+			currentRating = bookInfo.UserRating
+			ratingSubtitle = fmt.Sprintf("Change rating (currently: %.1f⭐️)", currentRating)
+		} else {
+			ratingSubtitle = "Assign rating"
+		}
+
+		if bookInfo.Shelves != "" {
+			shelfSubtitle = fmt.Sprintf("Add/remove from shelves (currently: %s)", bookInfo.Shelves)
+			shelfSymbol = " 🏷️"
+		} else {
+			shelfSubtitle = "Add to shelf"
+			shelfSymbol = ""
+		}
+	}
+	p := message.NewPrinter(language.English)
+	if ratingStr == "0.00" {
+		ratingStr = ""
+	} else {
+		if currentRating > 0 {
+			ratingStr = p.Sprintf("%.1f⭐️ %s☆%d", currentRating, ratingStr, book.Raters)
+		} else {
+			ratingStr = p.Sprintf("%s☆%d", ratingStr, book.Raters)
+		}
+	}
+
+	subtitle := fmt.Sprintf("%s, %s (%v) %s", position, book.Authors, book.ReleaseYear, ratingStr)
+	if book.SeriesPosition != "" {
+		subtitle = fmt.Sprintf("#%s · %s", book.SeriesPosition, subtitle)
+	}
+	if book.MatchedBy != "" {
+		subtitle = fmt.Sprintf("✓ Exact match for %s · %s (%v) %s", book.MatchedBy, book.Authors, book.ReleaseYear, ratingStr)
+	}
+
+	item := map[string]interface{}{
+		"title":    book.Title + " " + userLibrarySymbol + shelfSymbol,
+		"subtitle": subtitle,
+		"valid":    true,
+		"icon": map[string]string{
+			"path": coverPath(book.ImageURL),
+		},
+		"mods": map[string]interface{}{
+			"cmd": map[string]interface{}{
+				"subtitle": readingStatusSubtitle,
+				"valid":    true,
+				"variables": map[string]interface{}{ // Additional metadata
+					"current_bookID": book.ID,
+				},
+				"arg": "",
+				//"subtitle": book.Description, // Or another field as needed
+			},
+
+			"alt": map[string]interface{}{
+				"valid":    true,
+				"arg":      "",
+				"subtitle": ratingSubtitle,
+				"variables": map[string]interface{}{
+					"current_bookID": book.ID,
+					"current_rating": currentRating,
+				},
+			},
+			"ctrl": map[string]interface{}{
+				"valid":    true,
+				"arg":      "",
+				"subtitle": shelfSubtitle,
+				"variables": map[string]interface{}{
+					"current_bookID": book.ID,
+				},
+			},
+		},
+		"arg": baseURL + book.Slug,
+		"variables": map[string]interface{}{ // Additional metadata
+			"current_bookID": book.ID,
+			"release_year":   book.ReleaseYear,
+		},
+	}
+	details := bookDetails{
+		ID:          book.ID,
+		Title:       book.Title,
+		Authors:     book.Authors,
+		ReleaseYear: book.ReleaseYear,
+		Rating:      ratingStr,
+		Description: book.Description,
+		URL:         baseURL + book.Slug,
+	}
+	if book.ImageURL != "" {
+		details.CoverPath = coverPath(book.ImageURL)
+	}
+	if bookInfo, exists := bookStatusMap[book.ID]; exists {
+		details.Library = strings.TrimSpace(ReadStatus[bookInfo.StatusID] + " " + userLibrarySymbol + " " + bookInfo.Shelves)
+	}
+	addDetails(item, details)
+	return item
 }
 
 func SearchBookDatabase(searchString string) {
@@ -271,58 +279,77 @@ func SearchBookDatabase(searchString string) {
 	startTime := time.Now()
 	// Validate the token format
 
-	// Filter the search query
-	previousSearch := os.Getenv("CURRENT_DB_SEARCH")
-	// previousSearch = strings.ReplaceAll(previousSearch, " ", ".")
-	LogF("Previous search: %s", previousSearch)
-	var sortFlag string
-	for key := range orderClauses {
-		if strings.Contains(searchString, key) {
-			// Remove the sorting flag from searchString
-			searchString = strings.ReplaceAll(searchString, key, "")
-			sortFlag = key
-			break
-		}
+	// --page=N, from "Load more" (see catalogPages.go)
+	fullSearch := searchString
+	searchString, pages := extractPageFlag(searchString)
+	// --new, --owned (see catalogFilter.go)
+	searchString, filter := extractLibraryFilter(searchString)
+	// --y, --t+, ... (see sorting.go)
+	searchString, sortSpecs, err := extractSortFlags(searchString)
+	if err != nil {
+		serveErrorItem("Invalid sort", err)
+		return
 	}
 	searchString = filterSearchQuery(searchString)
-	if searchString == previousSearch {
-		LogF("Search string is the same as the previous search")
-		books = loadCachedSearchResults()
-	} else {
-		books = queryRemoteDatabase(searchString)
-		// Collect all image URLs
-		var imageURLs []string
-		for _, book := range books {
-			if book.ImageURL != "" {
-				imageURLs = append(imageURLs, book.ImageURL)
-			}
-		}
-
-		// Call fetchImages once with the complete list
-		fetchImages(imageURLs)
+	kind, kindSearch, drillDownID, isKind := splitCatalogKind(searchString)
+	// drill-downs and exact lookups come in one piece
+	_, isIdentifier := detectCatalogIdentifier(searchString)
+	paginated := !isKind && !isIdentifier
+	if !paginated {
+		pages = 1
+	}
 
+	// authors, series, lists and users (see catalogKinds.go)
+	switch {
+	case isKind && drillDownID != 0 && kind.QueryType == "User":
+		lists, err := drillDownLists(drillDownID)
+		if err != nil {
+			serveErrorItem("Cannot load the lists", err)
+			return
+		}
+		serveCatalogEntries(kind, kindSearch, lists)
+		return
+	case isKind && drillDownID == 0 && kindSearch == "":
+		serveCatalogEntries(kind, "", nil)
+		return
+	case isKind && drillDownID == 0:
+		entries, err := searchCatalogKind(kind, kindSearch)
+		if err != nil {
+			serveErrorItem("Catalog search failed", err)
+			return
+		}
+		serveCatalogEntries(kind, kindSearch, entries)
+		return
 	}
 
-	if sortFlag != "" {
-		LogF(fmt.Sprintf("AHA there is a sortflag: %s", sortFlag))
-
-		// Iterate over orderClauses keys
-		// Apply sorting based on the matched key
-		switch sortFlag {
-		case "--y":
-			sort.Slice(books, func(i, j int) bool {
-				return books[i].ReleaseYear > books[j].ReleaseYear // Descending
-			})
-		case "--r":
-			sort.Slice(books, func(i, j int) bool {
-				return books[i].Rating > books[j].Rating // Descending
-			})
-		case "--t":
-			sort.Slice(books, func(i, j int) bool {
-				return books[i].Title < books[j].Title // Ascending
-			})
+	// books come from the search cache when possible (see searchCache.go)
+	cache, err := openSearchCache(searchCachePath)
+	if err != nil {
+		LogF("Search cache unavailable: %v", err)
+	}
+	defer cache.Close()
+	cacheKey := searchCacheKey(searchString)
+	sortKey := sortSpecsKey(sortSpecs)
+
+	var fetchedAt time.Time
+	books, fromCache := cache.Get(cacheKey, pages, sortKey, false)
+	if fromCache {
+		LogF("Search results loaded from cache")
+	} else {
+		books, fetchedAt, err = fetchCatalogBooks(cache, cacheKey, searchString, pages, kind, drillDownID)
+		if err != nil {
+			stale, found := cache.Get(cacheKey, pages, sortKey, true)
+			if !found {
+				serveErrorItem("Catalog search failed", err)
+				return
+			}
+			LogF("Catalog search failed (%v), showing expired cached results", err)
+			books, fromCache = stale, true
+		} else {
+			fetchCatalogCovers(books)
 		}
 	}
+
 	result := make(map[string]interface{})
 	// Assign "cache" as a nested map
 	// result["cache"] = map[string]interface{}{
@@ -335,129 +362,47 @@ func SearchBookDatabase(searchString string) {
 	bookStatusMap, err := fetchBookIDs()
 
 	if err != nil {
-		fmt.Println("Error fetching book IDs:", err)
+		LogF("Error fetching book IDs: %v", err)
+		serveErrorItem("Cannot open your library", err)
 		return
 	}
+	sortCatalogBooks(books, bookStatusMap, sortSpecs)
+	if !fromCache {
+		cache.Put(cacheKey, pages, sortKey, books, fetchedAt)
+	}
 	elapsedTime := time.Since(startTime)
 	LogF("Execution time before serializing: %d ms", elapsedTime.Milliseconds())
-	// Serialize the struct to JSON
-	bookJSON, err := json.Marshal(books)
-	if err != nil {
-		LogF("Error marshalling struct:", err)
-		return
-	}
 
-	bookTotal := len(books)
+	// numbered against the server's count when more pages can be loaded;
+	// filtered results only know their own count
+	shown := filter.apply(books, bookStatusMap)
+	serverTotal := len(books)
+	if paginated {
+		serverTotal = catalogTotal(books)
+	}
+	bookTotal := serverTotal
+	if filter != nil {
+		bookTotal = len(shown)
+	}
 	bookCount := 0
 	elapsedTime = time.Since(startTime)
 
 	LogF("Execution time before starting loop: %d ms", elapsedTime.Milliseconds())
-	for _, book := range books {
-		var currentRating float64
+	for _, book := range shown {
 		bookCount++
-		// Format rating as string
-		ratingStr := fmt.Sprintf("%.2f", book.Rating)
-		// fetchImages([]string{book.ImageURL})
-		// Extract cover file name from image_url
-		coverFile := ""
-		if book.ImageURL != "" {
-			coverFile = path.Base(book.ImageURL)
-		}
-		// Check if book_id exists in the map and compare the status_id
-		var userLibrarySymbol string
-		readingStatusSubtitle := "Assign reading status"
-		ratingSubtitle := "Assign rating"
-		var shelfSubtitle string
-		var shelfSymbol string
-		if bookInfo, exists := bookStatusMap[book.ID]; exists {
-			readingStatusSubtitle = "Change reading status"
-			userLibrarySymbol = ReadStatusEmoji[bookInfo.StatusID]
-			switch bookInfo.StatusID {
-			case 1:
-				readingStatusSubtitle = "Change reading status (currently: to read)"
-			case 2:
-				readingStatusSubtitle = "Change reading status (currently: reading)"
-			case 3: // Multiple cases can share the same block
-				readingStatusSubtitle = "Change reading status (currently: read)"
-			case 4: // Multiple cases can share the same block
-				readingStatusSubtitle = "Change reading status (currently: DNF)"
-			default:
-				readingStatusSubtitle = "Assign reading status"
-			}
-			if bookInfo.UserRating > 0 {
-				currentRating = bookInfo.UserRating
-				ratingSubtitle = fmt.Sprintf("Change rating (currently: %.1f⭐️)", currentRating)
-			} else {
-				ratingSubtitle = "Assign rating"
-			}
-
-			if bookInfo.Shelves != "" {
-				shelfSubtitle = fmt.Sprintf("Add/remove from shelves (currently: %s)", bookInfo.Shelves)
-				shelfSymbol = " 🏷️"
-			} else {
-				shelfSubtitle = "Add to shelf"
-				shelfSymbol = ""
-			}
-		}
-		p := message.NewPrinter(language.English)
-		if ratingStr == "0.00" {
-			ratingStr = ""
-		} else {
-			if currentRating > 0 {
-				ratingStr = p.Sprintf("%.1f⭐️ %s☆%d", currentRating, ratingStr, book.Raters)
-			} else {
-				ratingStr = p.Sprintf("%s☆%d", ratingStr, book.Raters)
-			}
-		}
-
-		// Append formatted data to the result
-		result["items"] = append(result["items"].([]map[string]interface{}), map[string]interface{}{
-			"title":    book.Title + " " + userLibrarySymbol + shelfSymbol,
-			"subtitle": fmt.Sprintf("%v/%v, %s (%v) %s", bookCount, bookTotal, book.Authors, book.ReleaseYear, ratingStr),
-			"valid":    true,
-			"icon": map[string]string{
-				"path": filepath.Join(tempDir, coverFile),
-			},
-			"mods": map[string]interface{}{
-				"cmd": map[string]interface{}{
-					"subtitle": readingStatusSubtitle,
-					"valid":    true,
-					"variables": map[string]interface{}{ // Additional metadata
-						"current_bookID": book.ID,
-					},
-					"arg": "",
-					//"subtitle": book.Description, // Or another field as needed
-				},
-
-				"alt": map[string]interface{}{
-					"valid":    true,
-					"arg":      "",
-					"subtitle": ratingSubtitle,
-					"variables": map[string]interface{}{
-						"current_bookID": book.ID,
-						"current_rating": currentRating,
-					},
-				},
-				"ctrl": map[string]interface{}{
-					"valid":    true,
-					"arg":      "",
-					"subtitle": shelfSubtitle,
-					"variables": map[string]interface{}{
-						"current_bookID": book.ID,
-					},
-				},
-			},
-			"arg": baseURL + book.Slug,
-			"variables": map[string]interface{}{ // Additional metadata
-				"current_bookID": book.ID,
-				"release_year":   book.ReleaseYear,
-			},
-		})
-		result["variables"] = map[string]interface{}{
-
-			"CURRENT_DB_SEARCH": searchString,
-			"BOOK_SEARCH":       string(bookJSON),
+		item := catalogBookItem(book, fmt.Sprintf("%v/%v", bookCount, bookTotal), bookStatusMap)
+		result["items"] = append(result["items"].([]map[string]interface{}), item)
+	}
+	hidden := len(books) - len(shown)
+	if len(shown) == 0 && hidden > 0 {
+		result["items"] = append(result["items"].([]map[string]interface{}), filteredOutItem(filter, hidden))
+	}
+	if paginated && len(books) > 0 && len(books) < serverTotal {
+		loadMore := loadMoreItem(fullSearch, len(books), serverTotal, pages)
+		if hidden > 0 {
+			loadMore["subtitle"] = fmt.Sprintf("%s · %s hidden by --%s", loadMore["subtitle"], pluralBooks(hidden), filter.Name)
 		}
+		result["items"] = append(result["items"].([]map[string]interface{}), loadMore)
 	}
 	elapsedTime = time.Since(startTime)
 	LogF("Execution time after loop: %d ms", elapsedTime.Milliseconds())
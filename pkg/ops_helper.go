@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 )
@@ -13,3 +14,24 @@ func LogF(format string, args ...interface{}) {
 		fmt.Fprintln(os.Stderr, format)
 	}
 }
+
+// serveErrorItem prints a single Alfred item explaining why an action failed
+func serveErrorItem(title string, err error) {
+	result := map[string][]map[string]interface{}{
+		"items": {map[string]interface{}{
+			"title":    title,
+			"subtitle": err.Error(),
+			"valid":    false,
+			"icon": map[string]string{
+				"path": "icons/hopeless.png",
+			},
+		}},
+	}
+
+	jsonData, jsonErr := json.MarshalIndent(result, "", "  ")
+	if jsonErr != nil {
+		LogF("Error encoding JSON: %v", jsonErr)
+		return
+	}
+	fmt.Println(string(jsonData))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Write-through updates: after a mutation succeeds on Hardcover, the same
+// change is applied to books.db so that the library views reflect it
+// without waiting for the next sync.
+
+// withLibraryTransaction runs fn in a single transaction on the local database
+func withLibraryTransaction(fn func(tx *sql.Tx) error) error {
+	db, err := openLibraryDatabase(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// let the covers of new books finish before the process exits
+	covers.Wait()
+	return nil
+}
+
+// errFetchFirst aborts a write-through that needs a row from Hardcover; the
+// row is fetched outside the transaction, which then runs again
+var errFetchFirst = errors.New("row must be fetched first")
+
+// localUserBookID returns the user_book_id stored for a book, 0 if the book
+// is not in the local database
+func localUserBookID(tx *sql.Tx, bookID int) (int, error) {
+	var userBookID int
+	err := tx.QueryRow(`SELECT user_book_id FROM books WHERE book_id = ?`, bookID).Scan(&userBookID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up book %d: %w", bookID, err)
+	}
+	return userBookID, nil
+}
+
+// fetchUserBook downloads a single user_book with the fields of the library
+func fetchUserBook(userBookID int) (UserBook, error) {
+	var response struct {
+		Data struct {
+			UserBook *UserBook `json:"user_books_by_pk"`
+		} `json:"data"`
+	}
+	body, err := interrogateAPI(newGraphQLRequest(userBookByIDQuery, graphQLVars{
+		"id": userBookID,
+	}))
+	if err != nil {
+		return UserBook{}, err
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return UserBook{}, fmt.Errorf("error decoding user_book: %w", err)
+	}
+	if response.Data.UserBook == nil {
+		return UserBook{}, fmt.Errorf("user_book %d not found", userBookID)
+	}
+	return *response.Data.UserBook, nil
+}
+
+// fetchListBook downloads a single list_book with the fields of the library
+func fetchListBook(listBookID int) (ListBook, error) {
+	var response struct {
+		Data struct {
+			ListBook *ListBook `json:"list_books_by_pk"`
+		} `json:"data"`
+	}
+	body, err := interrogateAPI(newGraphQLRequest(listBookByIDQuery, graphQLVars{
+		"id":     listBookID,
+		"userID": userID,
+	}))
+	if err != nil {
+		return ListBook{}, err
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return ListBook{}, fmt.Errorf("error decoding list_book: %w", err)
+	}
+	if response.Data.ListBook == nil {
+		return ListBook{}, fmt.Errorf("list_book %d not found", listBookID)
+	}
+	return *response.Data.ListBook, nil
+}
+
+// storeUserBookLocally writes the user_book returned by a status or rating
+// mutation to the local database. Books already in the library are updated
+// in place; new ones (or books so far only on shelves) are fetched and added.
+func storeUserBookLocally(result MutationResult) error {
+	if result.UserBook == nil {
+		return fmt.Errorf("mutation returned no user_book")
+	}
+	changed := *result.UserBook
+
+	write := func(fetched *UserBook) error {
+		return withLibraryTransaction(func(tx *sql.Tx) error {
+			currentUserBookID, err := localUserBookID(tx, changed.Book.ID)
+			if err != nil {
+				return err
+			}
+			if currentUserBookID != changed.ID {
+				// a user_book the database does not know yet
+				if fetched == nil {
+					return errFetchFirst
+				}
+				if err := upsertUserBook(tx, *fetched); err != nil {
+					return err
+				}
+			} else {
+				_, err := tx.Exec(`UPDATE books SET status_id = ?, user_rating = IFNULL(?, 0) WHERE user_book_id = ?`,
+					changed.StatusID, changed.Rating, changed.ID)
+				if err != nil {
+					return fmt.Errorf("failed to update book %d: %w", changed.Book.ID, err)
+				}
+			}
+
+			if err := createRatingstable(tx); err != nil {
+				return err
+			}
+			return refreshFTSRows(tx, []int{changed.Book.ID})
+		})
+	}
+
+	if err := write(nil); err != errFetchFirst {
+		return err
+	}
+	userBook, err := fetchUserBook(changed.ID)
+	if err != nil {
+		return err
+	}
+	return write(&userBook)
+}
+
+// removeUserBookLocally drops a deleted user_book from the local database,
+// keeping the book as shelf-only when it is still on a shelf
+func removeUserBookLocally(userBookID int) error {
+	return withLibraryTransaction(func(tx *sql.Tx) error {
+		var bookID int
+		err := tx.QueryRow(`SELECT book_id FROM books WHERE user_book_id = ?`, userBookID).Scan(&bookID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up user_book %d: %w", userBookID, err)
+		}
+
+		if err := removeUserBook(tx, bookID, userBookID); err != nil {
+			return err
+		}
+		if err := createRatingstable(tx); err != nil {
+			return err
+		}
+		return refreshFTSRows(tx, []int{bookID})
+	})
+}
+
+// addListBookLocally records a book added to a shelf
+func addListBookLocally(listBookID, bookID, shelfID int, shelfName string) error {
+	write := func(fetched *ListBook) error {
+		return withLibraryTransaction(func(tx *sql.Tx) error {
+			userBookID, err := localUserBookID(tx, bookID)
+			if err != nil {
+				return err
+			}
+			// a retried toggle, or a sync, may have stored the entry already
+			var stored int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM shelf WHERE list_book_id = ?`, listBookID).Scan(&stored); err != nil {
+				return fmt.Errorf("failed to look up shelf entry %d: %w", listBookID, err)
+			}
+			if userBookID == 0 {
+				// a book the database does not know yet
+				if fetched == nil {
+					return errFetchFirst
+				}
+				if err := upsertListBook(tx, *fetched); err != nil {
+					return err
+				}
+			} else {
+				if _, err := tx.Exec(`DELETE FROM shelf WHERE list_book_id = ?`, listBookID); err != nil {
+					return fmt.Errorf("failed to clear shelf entry %d: %w", listBookID, err)
+				}
+				_, err := tx.Exec(`INSERT INTO shelf (shelf_id, user_book_id, list_book_id, name) VALUES (?, ?, ?, ?)`,
+					shelfID, userBookID, listBookID, shelfName)
+				if err != nil {
+					return fmt.Errorf("failed to store shelf entry %d: %w", listBookID, err)
+				}
+			}
+
+			if stored == 0 {
+				if _, err := tx.Exec(`UPDATE bookshelves SET books_count = books_count + 1 WHERE shelf_id = ?`, shelfID); err != nil {
+					return fmt.Errorf("failed to update shelf count: %w", err)
+				}
+			}
+			if err := updateShelvesColumn(tx, bookID); err != nil {
+				return err
+			}
+			return refreshFTSRows(tx, []int{bookID})
+		})
+	}
+
+	if err := write(nil); err != errFetchFirst {
+		return err
+	}
+	listBook, err := fetchListBook(listBookID)
+	if err != nil {
+		return err
+	}
+	return write(&listBook)
+}
+
+// removeListBookLocally records a book removed from a shelf
+func removeListBookLocally(listBookID, shelfID int) error {
+	return withLibraryTransaction(func(tx *sql.Tx) error {
+		bookID, err := removeListBook(tx, listBookID)
+		if err != nil {
+			return err
+		}
+		if bookID == 0 {
+			// not stored locally: nothing to count or index
+			return nil
+		}
+
+		if _, err := tx.Exec(`UPDATE bookshelves SET books_count = MAX(books_count - 1, 0) WHERE shelf_id = ?`, shelfID); err != nil {
+			return fmt.Errorf("failed to update shelf count: %w", err)
+		}
+		if err := updateShelvesColumn(tx, bookID); err != nil {
+			return err
+		}
+		return refreshFTSRows(tx, []int{bookID})
+	})
+}
+
+// updateShelvesColumn recomputes books.shelves for a single book
+func updateShelvesColumn(tx *sql.Tx, bookID int) error {
+	_, err := tx.Exec(`
+		UPDATE books SET shelves = COALESCE((
+			SELECT GROUP_CONCAT(name, ', ')
+			FROM (SELECT DISTINCT name FROM shelf WHERE shelf.user_book_id = books.user_book_id)
+		), '')
+		WHERE book_id = ?`, bookID)
+	if err != nil {
+		return fmt.Errorf("failed to update shelves of book %d: %w", bookID, err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Hardcover allows 60 requests per minute per token: stay a little below it
+const defaultAPIBudget = 55
+
+const (
+	apiTimeout      = 30 * time.Second
+	apiMaxRetries   = 3
+	apiBaseBackoff  = time.Second
+	apiMaxRetryWait = 20 * time.Second // longer waits fail fast instead of freezing Alfred
+)
+
+// apiBudget is the request budget shared by every invocation of the binary.
+// It lives in the data folder, guarded by an exclusive file lock.
+type apiBudget struct {
+	WindowStart  int64 `json:"window_start"`  // unix time of the current one-minute window
+	Count        int   `json:"count"`         // requests sent in the current window
+	BlockedUntil int64 `json:"blocked_until"` // unix time before which the server asked us to wait
+}
+
+// BudgetError is returned when a request would exceed the rate limit
+type BudgetError struct {
+	Limit int
+	Wait  time.Duration
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("Hardcover rate limit reached (%d requests/minute), try again in %ds",
+		e.Limit, int(e.Wait.Seconds()+0.5))
+}
+
+// withBudgetFile loads the shared budget, lets fn modify it and writes it back
+// while holding an exclusive lock, so concurrent invocations cooperate
+func withBudgetFile(fn func(budget *apiBudget) error) error {
+	if dataFolder == "" {
+		return fn(&apiBudget{})
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dataFolder, "apiBudget.lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open budget lock: %w", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock budget file: %w", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	budgetFile := filepath.Join(dataFolder, "apiBudget.json")
+	var budget apiBudget
+	if data, err := os.ReadFile(budgetFile); err == nil {
+		if err := json.Unmarshal(data, &budget); err != nil {
+			LogF("Ignoring unreadable budget file: %v", err)
+			budget = apiBudget{}
+		}
+	}
+
+	if err := fn(&budget); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(budget)
+	if err != nil {
+		return fmt.Errorf("failed to encode budget: %w", err)
+	}
+	return os.WriteFile(budgetFile, data, 0644)
+}
+
+// reserveAPIRequest takes one request from the per-minute budget,
+// failing fast with a BudgetError when none is left
+func reserveAPIRequest() error {
+	var budgetErr error
+	err := withBudgetFile(func(budget *apiBudget) error {
+		now := time.Now()
+
+		if budget.BlockedUntil > now.Unix() {
+			budgetErr = &BudgetError{Limit: apiBudgetPerMinute, Wait: time.Unix(budget.BlockedUntil, 0).Sub(now)}
+			return nil
+		}
+
+		if now.Unix()-budget.WindowStart >= 60 {
+			budget.WindowStart = now.Unix()
+			budget.Count = 0
+		}
+		if budget.Count >= apiBudgetPerMinute {
+			windowEnd := time.Unix(budget.WindowStart+60, 0)
+			budgetErr = &BudgetError{Limit: apiBudgetPerMinute, Wait: windowEnd.Sub(now)}
+			return nil
+		}
+
+		budget.Count++
+		return nil
+	})
+	if err != nil {
+		// a broken budget file should not stop the workflow
+		LogF("API budget unavailable: %v", err)
+		return nil
+	}
+	return budgetErr
+}
+
+// blockAPIRequests records a server-imposed pause for every invocation
+func blockAPIRequests(until time.Time) {
+	err := withBudgetFile(func(budget *apiBudget) error {
+		if until.Unix() > budget.BlockedUntil {
+			budget.BlockedUntil = until.Unix()
+		}
+		return nil
+	})
+	if err != nil {
+		LogF("Failed to record API pause: %v", err)
+	}
+}
+
+// isRetryableStatus reports whether a response status is worth retrying
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// requestNotSent reports whether a transport error happened before the
+// request reached the server: the name did not resolve or the connection
+// was refused
+func requestNotSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryDelay honors Retry-After (seconds or HTTP date), falling back to
+// exponential backoff based on the attempt number
+func retryDelay(header http.Header, attempt int) time.Duration {
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			if wait := time.Until(date); wait > 0 {
+				return wait
+			}
+			return 0
+		}
+	}
+	return apiBaseBackoff << attempt
+}
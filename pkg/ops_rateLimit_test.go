@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestRequestNotSent(t *testing.T) {
+	wrap := func(err error) error {
+		return fmt.Errorf("error making HTTP request: %w", &url.Error{Op: "Post", URL: apiRoot, Err: err})
+	}
+	refused := wrap(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	unresolved := wrap(&net.DNSError{Err: "no such host", Name: "api.hardcover.app"})
+	reset := wrap(&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")})
+
+	if !requestNotSent(refused) || !requestNotSent(unresolved) {
+		t.Error("dial and DNS failures happen before the request is sent")
+	}
+	if requestNotSent(reset) || requestNotSent(errors.New("error reading response body")) {
+		t.Error("a failure after the request went out must not count as unsent")
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -19,9 +18,20 @@ func changeBookRating() {
 	if newRating == "0" {
 		newRating = ""
 	}
-	// get the user_book_id environment variable
-	var queryString string
 
+	// an empty rating is sent as null, removing the current one
+	var rating interface{}
+	if newRating != "" {
+		ratingFloat, err := strconv.ParseFloat(newRating, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error converting newRating: %s", err)
+			fmt.Printf("⚠️ Could not change book rating: %q is not a rating.\n", newRating)
+			return
+		}
+		rating = ratingFloat
+	}
+
+	var request GraphQLRequest
 	bookID := os.Getenv("current_bookID")
 	if bookID != "" {
 		//convert bookID to int
@@ -29,24 +39,27 @@ func changeBookRating() {
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error converting bookID: %s", err)
 		}
-		queryString = fmt.Sprintf(`insert_user_book(object: {book_id: %v, rating: "%s"})`, bookIDInt, newRating)
+		request = newGraphQLRequest(insertUserBookMutation, graphQLVars{
+			"object": map[string]interface{}{
+				"book_id": bookIDInt,
+				"rating":  rating,
+			},
+		})
 	} else {
 		fmt.Fprintf(os.Stderr, "No user_bookID or bookID found")
+		fmt.Println("⚠️ Could not change book rating: no book selected.")
+		return
 	}
 
-	query := fmt.Sprintf(`mutation {
-		%s {
-			id
-			error
-			user_book {
-			book {
-				id
-			}
-			}
-		}
-		}`, queryString)
-	// fmt.Fprintf(os.Stderr, "%s", query)
-	interrogateAPI(query)
+	result, err := runMutation(request, "insert_user_book")
+	if err != nil {
+		notificationString := fmt.Sprintf("⚠️ Could not change book rating: %v", err)
+		fmt.Println(notificationString)
+		return
+	}
+	if err := storeUserBookLocally(result); err != nil {
+		LogF("Failed to update the local library (fixed at next sync): %v", err)
+	}
 	if newRating == "" {
 		notificationString := "Book rating removed!🚀"
 		fmt.Println(notificationString)
@@ -62,10 +75,10 @@ func fetchServeRating() ([]byte, error) {
 	startTime := time.Now()
 
 	// Open SQLite database
-	db, err := sql.Open("sqlite3", databasePath)
+	db, err := openLibraryDatabase(databasePath)
 	if err != nil {
-		LogF("ERROR")
-		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+		serveErrorItem("Cannot open your library", err)
+		return nil, err
 	}
 	defer db.Close()
 
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -13,7 +14,9 @@ import (
 	"golang.org/x/text/message"
 )
 
-func changeBookStatus() {
+// changeBookStatus sets the status in newStatus; the reads follow (see
+// readDates.go), dated dateString or today
+func changeBookStatus(dateString string) {
 	//getting newStatus from environment variable
 	newStatusInt, err := strconv.Atoi(os.Getenv("newStatus"))
 	if err != nil {
@@ -21,62 +24,91 @@ func changeBookStatus() {
 	}
 
 	// get the user_book_id environment variable
-	userBookID := os.Getenv("current_user_bookID")
-	var queryString string
-
-	userBookIDInt, err := strconv.Atoi(userBookID)
+	userBookIDInt, err := strconv.Atoi(os.Getenv("current_user_bookID"))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error converting userBookID: %s", err)
 	}
-
-	if userBookIDInt > 0 {
-		//convert userBookID to int
-
-		queryString = fmt.Sprintf(`update_user_book(id: %v, object: {status_id: %v})`, userBookIDInt, newStatusInt)
-
-	} else {
+	bookIDInt := 0
+	if userBookIDInt <= 0 {
 		bookID := os.Getenv("current_bookID")
 		LogF("BookID: %s", bookID)
-		if bookID != "" {
-			//convert bookID to int
-			bookIDInt, err := strconv.Atoi(bookID)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error converting bookID: %s", err)
-			}
-			queryString = fmt.Sprintf(`insert_user_book(object: {book_id: %v, status_id: %v})`, bookIDInt, newStatusInt)
-		} else {
+		if bookID == "" {
 			fmt.Fprintf(os.Stderr, "No user_bookID or bookID found")
+			fmt.Println("⚠️ Could not change book status: no book selected.")
+			return
 		}
+		//convert bookID to int
+		bookIDInt, err = strconv.Atoi(bookID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error converting bookID: %s", err)
+		}
+	}
+	fmt.Println(setBookStatus(userBookIDInt, bookIDInt, newStatusInt, dateString))
+}
 
+// setBookStatus gives a library book (userBookID), or adds a book (bookID),
+// a new status, records the reads it starts or finishes, dated dateString
+// or today, and returns the notification
+func setBookStatus(userBookID, bookID, statusID int, dateString string) string {
+	readDate, err := parseReadDate(dateString, time.Now())
+	if err != nil {
+		return fmt.Sprintf("⚠️ Could not change book status: %v", err)
 	}
 
-	query := fmt.Sprintf(`mutation {
-	%s {
-		id
-		error
-		user_book {
-		book {
-			id
-		}
+	var request GraphQLRequest
+	var mutationField string
+	if userBookID > 0 {
+		mutationField = "update_user_book"
+		request = newGraphQLRequest(updateUserBookMutation, graphQLVars{
+			"id": userBookID,
+			"object": map[string]interface{}{
+				"status_id": statusID,
+			},
+		})
+	} else {
+		mutationField = "insert_user_book"
+		request = newGraphQLRequest(insertUserBookMutation, graphQLVars{
+			"object": map[string]interface{}{
+				"book_id":   bookID,
+				"status_id": statusID,
+			},
+		})
+	}
+
+	result, err := runMutation(request, mutationField)
+	if err != nil {
+		return fmt.Sprintf("⚠️ Could not change book status: %v", err)
+	}
+	if err := storeUserBookLocally(result); err != nil {
+		LogF("Failed to update the local library (fixed at next sync): %v", err)
+	}
+	notificationString := fmt.Sprintf("Book status changed to '%s'.", ReadStatus[statusID])
+	if result.UserBook != nil {
+		dates, err := recordStatusDates(result.UserBook.ID, statusID, readDate)
+		if err != nil {
+			notificationString += fmt.Sprintf(" ⚠️ Could not record the reading dates: %v", err)
+		} else if dates != "" {
+			notificationString = fmt.Sprintf("Book status changed to '%s' (%s).", ReadStatus[statusID], dates)
 		}
 	}
-	}`, queryString)
-	// fmt.Fprintf(os.Stderr, "%s", query)
-	interrogateAPI(query)
-	notificationString := fmt.Sprintf("Book status changed to '%s'.", ReadStatus[newStatusInt])
-	fmt.Println(notificationString)
+	return notificationString
 }
 
-func fetchServeStatus() ([]byte, error) {
+// typedDate tells a date being typed in the status list from a status name
+var typedDate = regexp.MustCompile(`^[0-9][0-9-]*$`)
+
+func fetchServeStatus(input string) ([]byte, error) {
 	// a function to serve user's library book count by status
+	// input filters the statuses; when changing a status it can also be the
+	// date of the read (today, yesterday or YYYY-MM-DD)
 	// Start timing
 	startTime := time.Now()
 
 	// Open SQLite database
-	db, err := sql.Open("sqlite3", databasePath)
+	db, err := openLibraryDatabase(databasePath)
 	if err != nil {
-		LogF("ERROR")
-		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+		serveErrorItem("Cannot open your library", err)
+		return nil, err
 	}
 	defer db.Close()
 
@@ -125,6 +157,18 @@ func fetchServeStatus() ([]byte, error) {
 			LogF("Invalid current_bookID: %v", err)
 		}
 	}
+	changing := currentStatusID >= 0 || currentBookID != 0
+	input = strings.TrimSpace(input)
+	readDate := ""
+	var dateErr error
+	if changing && input != "" {
+		readDate, dateErr = parseReadDate(input, time.Now())
+		if !typedDate.MatchString(input) {
+			// a word filters the statuses
+			dateErr = nil
+		}
+	}
+
 	// Create the result object
 	result := map[string][]map[string]interface{}{
 		"items": {},
@@ -143,20 +187,55 @@ func fetchServeStatus() ([]byte, error) {
 			if statusID == currentStatusID {
 				continue
 			}
+			// a date only applies to the statuses that start or finish a read
+			if readDate != "" && statusID != 2 && statusID != 3 {
+				continue
+			}
+			if readDate == "" && !strings.Contains(strings.ToLower(ReadStatus[statusID]), strings.ToLower(input)) {
+				continue
+			}
 			p := message.NewPrinter(language.English)
 
 			subtitleAdd := fmt.Sprintf("↩️ to list %s", ReadStatus[statusID])
 			breadCrumb := "listStatusBooks"
 
 			// if a bookID is defined, I want to show which shelves the book is on
-			if currentStatusID >= 0 || currentBookID != 0 {
+			if changing {
 				subtitleAdd = fmt.Sprintf("↩️ to add to '%s'", ReadStatus[statusID])
 				breadCrumb = ""
-				if err != nil {
-					LogF("Invalid current_book_id: %v", err)
+				if readDate != "" {
+					subtitleAdd += ", dated " + readDate
 				}
 			}
 
+			mods := map[string]interface{}{
+
+				"cmd": map[string]interface{}{
+					"valid":    true,
+					"arg":      "listURL + slug",
+					"subtitle": "️open list on Hardcover",
+				},
+				"cmd+alt": map[string]interface{}{
+					"subtitle": backString,
+					"valid":    true,
+					"arg":      currentSearchString,
+					"variables": map[string]interface{}{
+						"newStatus":  "",
+						"breadCrumb": "",
+					},
+				},
+			}
+			if changing && readDate == "" && (statusID == 2 || statusID == 3) {
+				mods["alt"] = map[string]interface{}{
+					"subtitle": fmt.Sprintf("⌥↩️ to add to '%s', dated yesterday", ReadStatus[statusID]),
+					"valid":    true,
+					"arg":      "yesterday",
+					"variables": map[string]interface{}{
+						"newStatus":  statusID,
+						"breadCrumb": "",
+					},
+				}
+			}
 			// Append data to the result
 			result["items"] = append(result["items"], map[string]interface{}{
 				"title":    p.Sprintf("%s (%d)", ReadStatus[statusID], count),
@@ -169,24 +248,8 @@ func fetchServeStatus() ([]byte, error) {
 				"icon": map[string]string{
 					"path": ReadStatusIcon[statusID],
 				},
-				"mods": map[string]interface{}{
-
-					"cmd": map[string]interface{}{
-						"valid":    true,
-						"arg":      "listURL + slug",
-						"subtitle": "️open list on Hardcover",
-					},
-					"cmd+alt": map[string]interface{}{
-						"subtitle": backString,
-						"valid":    true,
-						"arg":      currentSearchString,
-						"variables": map[string]interface{}{
-							"newStatus":  "",
-							"breadCrumb": "",
-						},
-					},
-				},
-				"arg": "",
+				"mods": mods,
+				"arg":  readDate,
 			})
 		}
 	}
@@ -194,6 +257,23 @@ func fetchServeStatus() ([]byte, error) {
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error during rows iteration: %w", err)
 	}
+	if len(result["items"]) == 0 {
+		subtitle := "Type part of a status name"
+		if changing {
+			subtitle = "Type a status, or the date of the read: today, yesterday or YYYY-MM-DD"
+		}
+		if dateErr != nil {
+			subtitle = dateErr.Error()
+		}
+		result["items"] = append(result["items"], map[string]interface{}{
+			"title":    fmt.Sprintf("Nothing matches '%s'", input),
+			"subtitle": subtitle,
+			"valid":    false,
+			"icon": map[string]string{
+				"path": "icons/hopeless.png",
+			},
+		})
+	}
 
 	// Convert the result to JSON
 	jsonData, err := json.MarshalIndent(result, "", "  ")
@@ -15,15 +15,7 @@ import (
 )
 
 func fetchUserShelves() ([]byte, error) {
-	query := `query {me {lists {
-		name
-		id
-		books_count
-		public
-		slug
-	  }
-	}}`
-	shelves, err := interrogateAPI(query)
+	shelves, err := interrogateAPI(newGraphQLRequest(userShelvesQuery, nil))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to fetch shelves:", err)
 		return nil, err
@@ -52,10 +44,10 @@ func fetchServeShelves() ([]byte, error) {
 	startTime := time.Now()
 
 	// Open SQLite database
-	db, err := sql.Open("sqlite3", databasePath)
+	db, err := openLibraryDatabase(databasePath)
 	if err != nil {
-		LogF("ERROR")
-		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+		serveErrorItem("Cannot open your library", err)
+		return nil, err
 	}
 	defer db.Close()
 
@@ -190,9 +182,10 @@ func fetchServeShelves() ([]byte, error) {
 
 func toggleShelf(shelfAction string) {
 	// Open SQLite database
-	db, err := sql.Open("sqlite3", databasePath)
+	db, err := openLibraryDatabase(databasePath)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to open SQLite database:", err)
+		fmt.Printf("⚠️ Could not open your library: %v\n", err)
 		return
 	}
 
@@ -210,14 +203,20 @@ func toggleShelf(shelfAction string) {
 	}
 
 	shelfName := os.Getenv("current_shelfName")
-	query := ""
+	var request GraphQLRequest
+	var mutationField string
+	var listBookID int
 	notificationMessage := ""
 	switch shelfAction {
 	case "addList":
 		{
-			query = fmt.Sprintf(`mutation{
-	insert_list_book(object: {book_id: %d, list_id: %d}) {
-	id }}`, bookID, listID)
+			mutationField = "insert_list_book"
+			request = newGraphQLRequest(insertListBookMutation, graphQLVars{
+				"object": map[string]interface{}{
+					"book_id": bookID,
+					"list_id": listID,
+				},
+			})
 			notificationMessage = fmt.Sprintf("Book added to the %s shelf.", shelfName)
 		}
 	case "removeList":
@@ -225,21 +224,37 @@ func toggleShelf(shelfAction string) {
 			myUserListBookID, listName, err := getUserListBookID(db, bookID, listID)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, "Error getting user_book_id:", err)
+				fmt.Printf("⚠️ Could not remove book from the %s shelf: %v\n", shelfName, err)
 				return
 			}
 
-			query = fmt.Sprintf(`mutation {
-		delete_list_book(id: %d) {
-		  id
-		  list_id
-		}
-	  }`, myUserListBookID)
+			listBookID = myUserListBookID
+			mutationField = "delete_list_book"
+			request = newGraphQLRequest(deleteListBookMutation, graphQLVars{
+				"id": myUserListBookID,
+			})
 			notificationMessage = fmt.Sprintf("Book removed from the %s shelf.", listName)
 		}
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown shelf action:", shelfAction)
+		return
+	}
 
+	result, err := runMutation(request, mutationField)
+	if err != nil {
+		fmt.Printf("⚠️ Could not update the %s shelf: %v\n", shelfName, err)
+		return
 	}
 
-	interrogateAPI(query)
+	// write the change through to the local database
+	if shelfAction == "addList" && result.ID != nil {
+		err = addListBookLocally(*result.ID, bookID, listID, shelfName)
+	} else if shelfAction == "removeList" {
+		err = removeListBookLocally(listBookID, listID)
+	}
+	if err != nil {
+		LogF("Failed to update the local library (fixed at next sync): %v", err)
+	}
 	fmt.Println(notificationMessage) //to be shown in Alfred
 
 }
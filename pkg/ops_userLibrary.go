@@ -1,12 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -18,132 +15,83 @@ import (
 	"golang.org/x/text/message"
 )
 
-func interrogateAPI(myQuery string) ([]byte, error) {
-
+func searchLibrary(searchString string) ([]byte, error) {
 	// Start timing
 	startTime := time.Now()
 
-	if authToken == "" {
-		err := fmt.Errorf("error: Authorization token is empty")
-		fmt.Fprintln(os.Stderr, err) // Print error to stderr
-		return nil, err
-	}
-
-	// Build the request payload
-	requestBody := GraphQLRequest{
-		Query: myQuery,
-	}
-
-	payload, err := json.Marshal(requestBody)
-	if err != nil {
-		err = fmt.Errorf("error encoding JSON: %w", err)
-		fmt.Fprintln(os.Stderr, err) // Print error to stderr
+	result, _, err := libraryResult(searchString)
+	if result == nil {
+		// an error or a picker was served instead
 		return nil, err
 	}
 
-	// Make the HTTP request
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", apiRoot, bytes.NewBuffer(payload))
+	// Convert the result to JSON
+	jsonData, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
-		err = fmt.Errorf("error creating HTTP request: %w", err)
-		fmt.Fprintln(os.Stderr, err) // Print error to stderr
-		return nil, err
+		return nil, fmt.Errorf("failed to encode JSON: %w", err)
 	}
+	// Calculate and log execution time
+	elapsedTime := time.Since(startTime)
+	LogF("Execution time (library search): %d ms", elapsedTime.Milliseconds())
+	fmt.Println(string(jsonData))
+	return jsonData, nil
+}
 
-	// Set required headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", authToken)
+// libraryResult runs a library search and returns the Alfred items, with
+// the book of each item (0 for the others). Errors, and the status and
+// shelf pickers, are served directly: the result is nil then.
+func libraryResult(searchString string) (map[string][]map[string]interface{}, []int, error) {
+	//get the breadCrumb environment variable
+	breadCrumb := os.Getenv("breadCrumb")
 
-	// Execute the HTTP request
+	var backString string
 
-	resp, err := client.Do(req)
+	// --y, --t+, ... (see sorting.go)
+	searchString, sortSpecs, err := extractSortFlags(searchString)
 	if err != nil {
-		err = fmt.Errorf("error making HTTP request: %w", err)
-		fmt.Fprintln(os.Stderr, err) // Print error to stderr
-		return nil, err
+		serveErrorItem("Invalid sort", err)
+		return nil, nil, err
 	}
-	defer resp.Body.Close()
+	orderClause := orderByClause(sortSpecs)
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	// author:, shelf:, year: ... (see queryParser.go)
+	parsedQuery, err := parseLibraryQuery(searchString)
 	if err != nil {
-		err = fmt.Errorf("error reading response body: %w", err)
-		fmt.Fprintln(os.Stderr, err) // Print error to stderr
-		return nil, err
-
+		serveErrorItem("Invalid search", err)
+		return nil, nil, err
 	}
 
-	// Log the execution time
-	elapsedTime := time.Since(startTime)
-	LogF("Execution time (interrogateAPI): %d ms", elapsedTime.Milliseconds())
-
-	return body, nil
-}
-
-func filterLibrarySearch(query string) ([]string, bool, []string, string) {
-	// Split the query into words (tokens)
-	tokens := strings.Fields(query)
-	var STATUS_FLAG bool
-	var TAG_FRAG string
-	// Create a slice to store the filtered tokens
-	var filteredTokens []string
-
-	// Iterate over tokens and parse the search query
-	for _, token := range tokens {
-		if strings.HasPrefix(token, "@") {
-
-			STATUS_FLAG = true
-			// Removing '@' from the beginning of the string
-			cleanedInput := strings.TrimPrefix(token, "@")
-			TAG_FRAG = cleanedInput
-
-			// Check if it matches any value in the ReadStatus map
-			for key, status := range ReadStatus {
-				if cleanedInput == status {
-					whereClauses = append(whereClauses, fmt.Sprintf("b.status_id =%v ", key))
-					STATUS_FLAG = false
-					// filteredTokens = append(filteredTokens, token)
-				}
-			}
-
-			continue
-
-		}
-		filteredTokens = append(filteredTokens, token)
+	// Open SQLite database
+	db, err := openLibraryDatabase(databasePath)
+	if err != nil {
+		serveErrorItem("Cannot open your library", err)
+		return nil, nil, err
 	}
-	return filteredTokens, STATUS_FLAG, whereClauses, TAG_FRAG
-}
-
-func searchLibrary(searchString string) ([]byte, error) {
-	// Start timing
-	startTime := time.Now()
-	var TAG_FRAG string
-
-	//get the breadCrumb environment variable
-	breadCrumb := os.Getenv("breadCrumb")
-	var terms []string
-	var STATUS_FLAG bool
-
-	var orderClause string
-	var backString string
+	defer db.Close()
 
-	// this should be integrated in the filterLibrarySearch function
-	for key := range orderClauses { // Loop through map keys
-		if strings.Contains(searchString, key) {
-			searchString = strings.ReplaceAll(searchString, key, "")
-			orderClause = orderClauses[key]
-			break // Stop after the first match
-		}
+	// #shelf tokens need the shelf list: an unfinished one opens the picker
+	if err := parsedQuery.resolveShelfTags(db); err != nil {
+		serveErrorItem("Cannot read your shelves", err)
+		return nil, nil, err
 	}
-	if searchString != "" {
-		terms, STATUS_FLAG, whereClauses, TAG_FRAG = filterLibrarySearch(searchString)
+	if parsedQuery.ShelfPending {
+		_, err := serveShelfPicker(db, searchString, parsedQuery)
+		return nil, nil, err
 	}
 
+	terms := parsedQuery.Text
+	STATUS_FLAG := parsedQuery.StatusPending
+	TAG_FRAG := parsedQuery.StatusFragment
+	whereClauses, args := parsedQuery.compile()
+
 	switch breadCrumb {
 	case "listShelfBooks":
-		currentShelfID := os.Getenv("current_listID")
-		// LogF("currentShelfID: %v", currentShelfID)
-		whereClauses = append(whereClauses, fmt.Sprintf("s.shelf_id = %v ", currentShelfID))
+		currentShelfID, err := strconv.Atoi(os.Getenv("current_listID"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error converting current_listID: %s", err)
+		}
+		whereClauses = append(whereClauses, "s.shelf_id = ?")
+		args = append(args, currentShelfID)
 		backString = "⬅️ back to shelves"
 
 	case "listStatusBooks":
@@ -152,8 +100,8 @@ func searchLibrary(searchString string) ([]byte, error) {
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error converting current_statusID: %s", err)
 		}
-		whereClauses = append(whereClauses, fmt.Sprintf("b.status_id = %v ", current_StatusID))
-		// whereClauseCrumb = fmt.Sprintf(" WHERE b.status_id = %v ", current_StatusID)
+		whereClauses = append(whereClauses, "b.status_id = ?")
+		args = append(args, current_StatusID)
 
 	case "listRatings":
 		currentRating, err := strconv.ParseFloat(os.Getenv("newRating"), 64)
@@ -161,26 +109,26 @@ func searchLibrary(searchString string) ([]byte, error) {
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error converting current_rating: %s", err)
 		}
-		whereClauses = append(whereClauses, fmt.Sprintf("b.user_rating = %.1f ", currentRating))
+		whereClauses = append(whereClauses, "b.user_rating = ?")
+		args = append(args, currentRating)
 
 	}
-	// Open SQLite database
-	db, err := sql.Open("sqlite3", databasePath)
 
+	// few exact hits: add the books matching despite typos
+	whereClauses, args, fuzzyOrder, approximate, err := widenWithFuzzyMatches(db, parsedQuery, whereClauses, args)
 	if err != nil {
-		fmt.Fprintf(os.Stdout, "failed to open SQLite database: %v\n", err)
-		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+		serveErrorItem("Library search failed", err)
+		return nil, nil, err
 	}
-	defer db.Close()
-	// SQL query and arguments
+
+	// SQL query
 	var query string
-	var args []interface{}
 
 	query = `
 		SELECT 
 			b.book_id,	
 			b.title,
-			f.authors,
+			f.authors_display,
 			b.release_year,
 			b.user_rating,
 			b.rating,
@@ -190,6 +138,7 @@ func searchLibrary(searchString string) ([]byte, error) {
 			b.status_id,
 			b.user_book_id,
 			b.slug,
+			IFNULL(b.description, ''),` + progressColumns + `,` + readCountColumn + `,
 			COUNT(*) OVER () AS total_count,	
 			COUNT(*) FILTER (WHERE b.status_id = 1) OVER () AS count_status_1,
 			COUNT(*) FILTER (WHERE b.status_id = 2) OVER () AS count_status_2,
@@ -203,19 +152,7 @@ func searchLibrary(searchString string) ([]byte, error) {
 			LEFT JOIN shelf s ON b.user_book_id = s.user_book_id	
 		
 	`
-	// Append '*' to each term
-	if len(terms) > 0 {
-		var searchTerms []string
-		for _, term := range terms {
-			searchTerms = append(searchTerms, term+"*")
-		}
-		// Join terms with space
-		searchPattern := strings.Join(searchTerms, " ")
-		whereClauses = append(whereClauses, "(books_authors_fts MATCH ?)")
-		args = append(args, searchPattern)
-
-	}
-	// Combine the WHERE clause with OR conditions
+	// Combine the WHERE clauses with AND
 	if len(whereClauses) > 0 {
 		query += `
 			
@@ -228,6 +165,11 @@ func searchLibrary(searchString string) ([]byte, error) {
 	// Add ORDER BY clause
 	if orderClause != "" {
 		query += orderClause
+	} else if len(fuzzyOrder) > 0 {
+		// exact matches first, then the closest approximate ones
+		rankClause, rankArgs := fuzzyRankClause(fuzzyOrder)
+		query += rankClause
+		args = append(args, rankArgs...)
 	}
 
 	// LogF("Query: %s", query)
@@ -236,7 +178,7 @@ func searchLibrary(searchString string) ([]byte, error) {
 	rows, err := db.Query(query, args...)
 	if err != nil {
 		fmt.Fprintln(os.Stdout, "failed to execute query:", err) // Print to stdout
-		return nil, fmt.Errorf("failed to execute query: %w", err)
+		return nil, nil, fmt.Errorf("failed to execute query: %w", err)
 
 	}
 	defer rows.Close()
@@ -249,6 +191,7 @@ func searchLibrary(searchString string) ([]byte, error) {
 	result := map[string][]map[string]interface{}{
 		"items": {},
 	}
+	var bookIDs []int
 
 	// LogF("current terms: %s", strings.Join(terms, " "))
 
@@ -257,12 +200,14 @@ func searchLibrary(searchString string) ([]byte, error) {
 	// Iterate through the rows
 	for rows.Next() {
 		nonZeroResults = true
-		var title, authors, shelves, coverFile, slug string
+		var title, authors, shelves, coverFile, slug, description string
+		var progress readingProgress
+		var readCount int
 		var user_rating, rating sql.NullFloat64
 		var statusID, book_id, user_book_id, release_year, ratings_count, statusCount1, statusCount2, statusCount3, statusCount4 int
 		bookCount++
 
-		err := rows.Scan(&book_id, &title, &authors, &release_year, &user_rating, &rating, &ratings_count, &shelves, &coverFile, &statusID, &user_book_id, &slug, &resultCount, &statusCount1, &statusCount2, &statusCount3, &statusCount4)
+		err := rows.Scan(&book_id, &title, &authors, &release_year, &user_rating, &rating, &ratings_count, &shelves, &coverFile, &statusID, &user_book_id, &slug, &description, &progress.Pages, &progress.Seconds, &progress.TotalPages, &progress.TotalSeconds, &readCount, &resultCount, &statusCount1, &statusCount2, &statusCount3, &statusCount4)
 		if err != nil {
 			LogF("failed to scan row: %v", err)
 			continue
@@ -350,13 +295,33 @@ func searchLibrary(searchString string) ([]byte, error) {
 		}
 		// Convert release_year to string without formatting
 		releaseYearStr := fmt.Sprintf("%d", release_year)
+		// books found despite a typo
+		if approximate[book_id] {
+			title = "≈ " + title
+		}
+		subtitle := p.Sprintf("%d/%d %s (%s) %s (%s)", bookCount, resultCount, authors, releaseYearStr, ratingStr, overallRating)
+		progressLabel := ""
+		if statusID == 2 {
+			progressLabel = progress.label()
+		}
+		if progressLabel != "" {
+			subtitle += " · " + progressLabel
+		}
+		if readCountLabel(readCount) != "" {
+			subtitle += " · " + readCountLabel(readCount)
+		}
+		// books without a cover (or whose cover was evicted) get the workflow icon
+		iconPath := "icon.png"
+		if coverFile != "" {
+			iconPath = filepath.Join(coverDir, coverFile)
+		}
 		// Append data to the result
-		result["items"] = append(result["items"], map[string]interface{}{
+		item := map[string]interface{}{
 			"title":    title + " " + ReadStatusEmoji[statusID],
-			"subtitle": p.Sprintf("%d/%d %s (%s) %s (%s)", bookCount, resultCount, authors, releaseYearStr, ratingStr, overallRating),
+			"subtitle": subtitle,
 			"valid":    true,
 			"icon": map[string]string{
-				"path": filepath.Join(coverDir, coverFile),
+				"path": iconPath,
 			},
 
 			"mods": map[string]interface{}{
@@ -413,13 +378,69 @@ func searchLibrary(searchString string) ([]byte, error) {
 			"variables": map[string]interface{}{
 				"searchSource": "",
 			},
-		})
-
+		}
+		details := bookDetails{
+			ID:          book_id,
+			Title:       strings.TrimPrefix(title, "≈ "),
+			Authors:     authors,
+			ReleaseYear: release_year,
+			Rating:      overallRating,
+			Description: description,
+			URL:         baseURL + slug,
+			Library:     strings.TrimSpace(ReadStatus[statusID] + " " + ReadStatusEmoji[statusID] + " " + progressLabel + " " + ratingStr + " " + shelveStatus),
+		}
+		// reading progress (see progress.go)
+		if statusID == 2 {
+			progressSubtitle := "Set reading progress: page, % or time"
+			if progressLabel != "" {
+				progressSubtitle += " (currently: " + progressLabel + ")"
+			}
+			item["mods"].(map[string]interface{})["fn"] = map[string]interface{}{
+				"valid":    true,
+				"arg":      "",
+				"subtitle": progressSubtitle,
+				"variables": map[string]interface{}{
+					"current_bookID": book_id,
+					"bookAction":     "progress",
+					"mySearchString": searchString,
+				},
+			}
+		}
+		// re-reads and reading history (see reread.go)
+		if statusID == 3 {
+			item["mods"].(map[string]interface{})["fn"] = map[string]interface{}{
+				"valid":    true,
+				"arg":      "",
+				"subtitle": "Start a re-read: back to Reading with a new read",
+				"variables": map[string]interface{}{
+					"current_user_bookID": user_book_id,
+					"bookAction":          "reread",
+					"mySearchString":      searchString,
+				},
+			}
+		}
+		if user_book_id > 0 {
+			item["mods"].(map[string]interface{})["ctrl+alt"] = map[string]interface{}{
+				"valid":    true,
+				"arg":      "",
+				"subtitle": "Show reading history",
+				"variables": map[string]interface{}{
+					"current_bookID": book_id,
+					"mySearchString": searchString,
+				},
+			}
+		}
+		if coverFile != "" {
+			details.CoverPath = filepath.Join(coverDir, coverFile)
+		}
+		addDetails(item, details)
+		result["items"] = append(result["items"], item)
+		bookIDs = append(bookIDs, book_id)
 	}
 
 	// Check for errors after iteration
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration: %w", err)
+		return nil, nil, fmt.Errorf("error during rows iteration: %w", err)
 	}
 	// Check if there are no results
 	if !nonZeroResults {
@@ -431,16 +452,80 @@ func searchLibrary(searchString string) ([]byte, error) {
 				"path": "icons/hopeless.png",
 			},
 		})
+		bookIDs = append(bookIDs, 0)
+	}
+
+	return result, bookIDs, nil
+}
+
+// serveShelfPicker lists the shelves matching an unfinished # token; picking
+// one replaces the token with the full shelf name
+func serveShelfPicker(db *sql.DB, searchString string, parsedQuery libraryQuery) ([]byte, error) {
+	// the search without the unfinished token
+	var kept []string
+	for _, token := range splitQueryTokens(searchString) {
+		if token.text != parsedQuery.ShelfToken {
+			kept = append(kept, token.text)
+		}
+	}
+	baseString := strings.Join(kept, " ")
+	if baseString != "" {
+		baseString += " "
+	}
+
+	rows, err := db.Query(`SELECT name, books_count FROM bookshelves WHERE name LIKE ? ESCAPE '\' ORDER BY name COLLATE NOCASE`,
+		"%"+escapeLike(parsedQuery.ShelfFragment)+"%")
+	if err != nil {
+		serveErrorItem("Cannot read your shelves", err)
+		return nil, fmt.Errorf("failed to query shelves: %w", err)
+	}
+	defer rows.Close()
+
+	result := map[string][]map[string]interface{}{
+		"items": {},
+	}
+	for rows.Next() {
+		var name string
+		var booksCount int
+		if err := rows.Scan(&name, &booksCount); err != nil {
+			LogF("failed to scan shelf: %v", err)
+			continue
+		}
+		myProcessedSearchString := baseString + shelfTagToken(name) + " "
+		result["items"] = append(result["items"], map[string]interface{}{
+			"title":        fmt.Sprintf("%s (%d)", name, booksCount),
+			"subtitle":     "Filter by shelf",
+			"valid":        true,
+			"autocomplete": myProcessedSearchString,
+			"variables": map[string]interface{}{
+				"searchSource":          "statusSearch",
+				"processedSearchString": myProcessedSearchString,
+			},
+			"icon": map[string]string{
+				"path": "icons/shelf.png",
+			},
+			"arg": myProcessedSearchString,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	if len(result["items"]) == 0 {
+		result["items"] = append(result["items"], map[string]interface{}{
+			"title":    "no shelf matches #" + parsedQuery.ShelfFragment + " 🙂",
+			"subtitle": "review please!",
+			"valid":    false,
+			"icon": map[string]string{
+				"path": "icons/hopeless.png",
+			},
+		})
 	}
 
-	// Convert the result to JSON
 	jsonData, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode JSON: %w", err)
 	}
-	// Calculate and log execution time
-	elapsedTime := time.Since(startTime)
-	LogF("Execution time (library search): %d ms", elapsedTime.Milliseconds())
 	fmt.Println(string(jsonData))
 	return jsonData, nil
 }
@@ -450,10 +535,9 @@ func fetchBookIDs() (map[int]BookInfoMap, error) {
 	startTime := time.Now()
 
 	// Open SQLite database
-	db, err := sql.Open("sqlite3", databasePath)
+	db, err := openLibraryDatabase(databasePath)
 	if err != nil {
-		LogF("ERROR")
-		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+		return nil, err
 	}
 	defer db.Close()
 
@@ -463,7 +547,11 @@ func fetchBookIDs() (map[int]BookInfoMap, error) {
 		book_id,
 		status_id,
 		user_rating,
-		COALESCE(GROUP_CONCAT(DISTINCT s.name) , '') AS shelves
+		COALESCE(GROUP_CONCAT(DISTINCT s.name) , '') AS shelves,
+		COUNT(DISTINCT s.shelf_id),
+		b.date_added,
+		(SELECT MAX(j.started_at) FROM journey j WHERE j.user_book_id = b.user_book_id),
+		(SELECT MAX(j.finished_at) FROM journey j WHERE j.user_book_id = b.user_book_id)
 	FROM books b
 	LEFT JOIN shelf s ON b.user_book_id = s.user_book_id
 	GROUP BY b.book_id
@@ -482,35 +570,25 @@ func fetchBookIDs() (map[int]BookInfoMap, error) {
 
 	// Iterate over rows and store the data in the map
 	for rows.Next() {
-		var bookID int
+		var bookID, shelfCount int
 		var shelves string
 		var userRating sql.NullFloat64
 		var statusID sql.NullInt64 // Handle NULL values
+		var dateAdded, startedAt, finishedAt sql.NullString
 
-		err := rows.Scan(&bookID, &statusID, &userRating, &shelves)
+		err := rows.Scan(&bookID, &statusID, &userRating, &shelves, &shelfCount, &dateAdded, &startedAt, &finishedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
-		if statusID.Valid || userRating.Valid {
-			bookStatusMap[bookID] = struct {
-				StatusID   int
-				UserRating float64
-				Shelves    string
-			}{
-				StatusID:   int(statusID.Int64), // Convert SQL NULL to int
-				UserRating: userRating.Float64,  // Convert SQL NULL to float64
-				Shelves:    shelves,
-			}
-		} else {
-			bookStatusMap[bookID] = struct {
-				StatusID   int
-				UserRating float64
-				Shelves    string
-			}{
-				StatusID:   0, // Default if NULL
-				UserRating: 0, // Default if NULL
-				Shelves:    shelves,
-			}
+		// SQL NULLs become zero values
+		bookStatusMap[bookID] = BookInfoMap{
+			StatusID:   int(statusID.Int64),
+			UserRating: userRating.Float64,
+			Shelves:    shelves,
+			ShelfCount: shelfCount,
+			DateAdded:  dateAdded.String,
+			StartedAt:  startedAt.String,
+			FinishedAt: finishedAt.String,
 		}
 	}
 
@@ -541,14 +619,21 @@ func deleteLibraryBook() {
 
 	} else {
 		fmt.Fprintf(os.Stderr, "No user_bookID or bookID found")
+		fmt.Println("⚠️ Could not remove book: no book selected.")
+		return
 	}
 
-	query := fmt.Sprintf(`mutation {
-		delete_user_book(id: %v) {
-		  book_id
-		}
-		}`, bookIDInt)
-	interrogateAPI(query)
+	_, err := runMutation(newGraphQLRequest(deleteUserBookMutation, graphQLVars{
+		"id": bookIDInt,
+	}), "delete_user_book")
+	if err != nil {
+		notificationString := fmt.Sprintf("⚠️ Could not remove book from library: %v", err)
+		fmt.Println(notificationString)
+		return
+	}
+	if err := removeUserBookLocally(bookIDInt); err != nil {
+		LogF("Failed to update the local library (fixed at next sync): %v", err)
+	}
 	notificationString := "Book eliminated from library 🚮"
 	fmt.Println(notificationString)
 }
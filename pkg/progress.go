@@ -0,0 +1,326 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reading progress of the books being read (-progress). The value is typed
+// as a page (123), a percentage (45%) or a listening time (2h13m, 1:05); it
+// is stored on the current read of the book, started today when there is
+// none, and shown in the library subtitle.
+
+// length returns the pages and audio length of a library book: those of the
+// edition in the library, else those of the book
+func (u UserBook) length() (int, int) {
+	pages, audioSeconds := u.Edition.Pages, u.Edition.AudioSeconds
+	if pages == 0 {
+		pages = u.Book.Pages
+	}
+	if audioSeconds == 0 {
+		audioSeconds = u.Book.AudioSeconds
+	}
+	return pages, audioSeconds
+}
+
+// readingProgress is where a reader is in a book, with the book's length
+// (0 when unknown)
+type readingProgress struct {
+	Pages        int
+	Seconds      int
+	TotalPages   int
+	TotalSeconds int
+}
+
+// audio tells whether the progress is counted in time
+func (p readingProgress) audio() bool {
+	return p.Seconds > 0 && p.Pages == 0
+}
+
+// label formats the progress, e.g. "p. 123/480 (26%)" or "2h13m/10h05m (22%)";
+// empty when nothing was recorded
+func (p readingProgress) label() string {
+	switch {
+	case p.Pages > 0 && p.TotalPages > 0:
+		return fmt.Sprintf("p. %d/%d (%d%%)", p.Pages, p.TotalPages, percentOf(p.Pages, p.TotalPages))
+	case p.Pages > 0:
+		return fmt.Sprintf("p. %d", p.Pages)
+	case p.Seconds > 0 && p.TotalSeconds > 0:
+		return fmt.Sprintf("%s/%s (%d%%)", formatListeningTime(p.Seconds), formatListeningTime(p.TotalSeconds), percentOf(p.Seconds, p.TotalSeconds))
+	case p.Seconds > 0:
+		return formatListeningTime(p.Seconds)
+	}
+	return ""
+}
+
+func percentOf(part, total int) int {
+	return int(math.Round(float64(part) * 100 / float64(total)))
+}
+
+// formatListeningTime writes seconds as 2h13m, or 45m under an hour
+func formatListeningTime(seconds int) string {
+	minutes := seconds / 60
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
+
+var (
+	pageProgress     = regexp.MustCompile(`^(?:p\.?)?(\d+)$`)
+	percentProgress  = regexp.MustCompile(`^(\d+(?:\.\d+)?)%$`)
+	durationProgress = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m(?:in)?)?(?:(\d+)s)?$`)
+	clockProgress    = regexp.MustCompile(`^(\d+):([0-5]\d)$`)
+)
+
+// parseProgress reads a typed progress against the current one, which
+// gives the book's length and whether it is read or listened to. A
+// percentage becomes pages, or time for audiobooks and books whose page
+// count is unknown.
+func parseProgress(input string, current readingProgress) (readingProgress, error) {
+	value := strings.ToLower(strings.Join(strings.Fields(input), ""))
+	next := readingProgress{TotalPages: current.TotalPages, TotalSeconds: current.TotalSeconds}
+
+	if match := pageProgress.FindStringSubmatch(value); match != nil {
+		next.Pages, _ = strconv.Atoi(match[1])
+		if next.TotalPages > 0 && next.Pages > next.TotalPages {
+			return next, fmt.Errorf("page %d is past the end of the book (%d pages)", next.Pages, next.TotalPages)
+		}
+		return next, nil
+	}
+
+	if match := percentProgress.FindStringSubmatch(value); match != nil {
+		percent, _ := strconv.ParseFloat(match[1], 64)
+		if percent > 100 {
+			return next, fmt.Errorf("%s is more than 100%%", input)
+		}
+		useTime := next.TotalSeconds > 0 && (current.audio() || next.TotalPages == 0)
+		switch {
+		case useTime:
+			next.Seconds = int(math.Round(percent / 100 * float64(next.TotalSeconds)))
+		case next.TotalPages > 0:
+			next.Pages = int(math.Round(percent / 100 * float64(next.TotalPages)))
+		default:
+			return next, fmt.Errorf("the length of this book is unknown: enter a page or a time instead")
+		}
+		return next, nil
+	}
+
+	seconds := -1
+	if match := clockProgress.FindStringSubmatch(value); match != nil {
+		hours, _ := strconv.Atoi(match[1])
+		minutes, _ := strconv.Atoi(match[2])
+		seconds = hours*3600 + minutes*60
+	} else if match := durationProgress.FindStringSubmatch(value); match != nil && value != "" {
+		hours, _ := strconv.Atoi(match[1])
+		minutes, _ := strconv.Atoi(match[2])
+		secs, _ := strconv.Atoi(match[3])
+		seconds = hours*3600 + minutes*60 + secs
+	}
+	if seconds < 0 {
+		return next, fmt.Errorf("%q is not a page (123), a percentage (45%%) or a time (2h13m)", input)
+	}
+	if next.TotalSeconds > 0 && seconds > next.TotalSeconds {
+		return next, fmt.Errorf("%s is past the end of the audiobook (%s)", formatListeningTime(seconds), formatListeningTime(next.TotalSeconds))
+	}
+	next.Seconds = seconds
+	return next, nil
+}
+
+// progressColumns selects the progress of the unfinished read of b, the
+// latest started, and the length of b
+const progressColumns = `
+	IFNULL((SELECT j.progress_pages FROM journey j WHERE j.user_book_id = b.user_book_id AND j.finished_at IS NULL
+		ORDER BY j.started_at DESC, j.journey_id DESC LIMIT 1), 0),
+	IFNULL((SELECT j.progress_seconds FROM journey j WHERE j.user_book_id = b.user_book_id AND j.finished_at IS NULL
+		ORDER BY j.started_at DESC, j.journey_id DESC LIMIT 1), 0),
+	IFNULL(b.pages, 0),
+	IFNULL(b.audio_seconds, 0)`
+
+// currentRead is the unfinished read of a library book
+type currentRead struct {
+	UserBookID int
+	StatusID   int
+	Title      string
+	ReadID     int    // 0 when the book has no unfinished read
+	StartedAt  string // of the read
+	Progress   readingProgress
+}
+
+// loadCurrentRead reads the unfinished read of a book from the library
+func loadCurrentRead(bookID int) (currentRead, error) {
+	var read currentRead
+	err := withLibraryTransaction(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT b.user_book_id, b.status_id, b.title, `+progressColumns+`
+			FROM books b WHERE b.book_id = ? AND b.user_book_id > 0`, bookID).Scan(
+			&read.UserBookID, &read.StatusID, &read.Title,
+			&read.Progress.Pages, &read.Progress.Seconds, &read.Progress.TotalPages, &read.Progress.TotalSeconds)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("this book is not in your library")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read book %d: %w", bookID, err)
+		}
+
+		var startedAt sql.NullString
+		err = tx.QueryRow(`SELECT journey_id, started_at FROM journey
+			WHERE user_book_id = ? AND finished_at IS NULL
+			ORDER BY started_at DESC, journey_id DESC LIMIT 1`, read.UserBookID).Scan(&read.ReadID, &startedAt)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to read the reads of book %d: %w", bookID, err)
+		}
+		read.StartedAt = startedAt.String
+		return nil
+	})
+	return read, err
+}
+
+// progressInput is the DatesReadInput of a progress update; the unit not
+// used is cleared
+func progressInput(progress readingProgress, startedAt string) map[string]interface{} {
+	input := map[string]interface{}{
+		"progress_pages":   nil,
+		"progress_seconds": nil,
+	}
+	if progress.Pages > 0 || progress.Seconds == 0 {
+		input["progress_pages"] = progress.Pages
+	} else {
+		input["progress_seconds"] = progress.Seconds
+	}
+	// the update replaces the read: keep its start date
+	if startedAt != "" {
+		input["started_at"] = startedAt
+	}
+	return input
+}
+
+// storeReadLocally writes a read returned by a mutation to the journey table
+func storeReadLocally(userBookID int, read UserBookRead) error {
+	return withLibraryTransaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`INSERT OR REPLACE INTO journey (journey_id, user_book_id, started_at, finished_at, progress_pages, progress_seconds)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			read.ID, userBookID, read.StartedAt, read.FinishedAt, read.ProgressPages, read.ProgressSeconds)
+		if err != nil {
+			return fmt.Errorf("failed to store read %d: %w", read.ID, err)
+		}
+		return nil
+	})
+}
+
+// setReadingProgress records the progress typed for the book in
+// current_bookID (-progress)
+func setReadingProgress(input string) {
+	bookID, err := strconv.Atoi(os.Getenv("current_bookID"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error converting bookID: %s", err)
+		fmt.Println("⚠️ Could not set reading progress: no book selected.")
+		return
+	}
+	read, err := loadCurrentRead(bookID)
+	if err != nil {
+		fmt.Printf("⚠️ Could not set reading progress: %v\n", err)
+		return
+	}
+	if read.StatusID != 2 {
+		fmt.Printf("⚠️ Could not set reading progress: '%s' is not a book you are reading.\n", read.Title)
+		return
+	}
+	progress, err := parseProgress(input, read.Progress)
+	if err != nil {
+		fmt.Printf("⚠️ Could not set reading progress: %v\n", err)
+		return
+	}
+
+	var request GraphQLRequest
+	var mutationField string
+	if read.ReadID > 0 {
+		mutationField = "update_user_book_read"
+		request = newGraphQLRequest(updateUserBookReadMutation, graphQLVars{
+			"id":     read.ReadID,
+			"object": progressInput(progress, read.StartedAt),
+		})
+	} else {
+		mutationField = "insert_user_book_read"
+		request = newGraphQLRequest(insertUserBookReadMutation, graphQLVars{
+			"userBookID": read.UserBookID,
+			"object":     progressInput(progress, time.Now().Format("2006-01-02")),
+		})
+	}
+
+	result, err := runMutation(request, mutationField)
+	if err != nil {
+		fmt.Printf("⚠️ Could not set reading progress: %v\n", err)
+		return
+	}
+	if result.UserBookRead != nil {
+		if err := storeReadLocally(read.UserBookID, *result.UserBookRead); err != nil {
+			LogF("Failed to update the local library (fixed at next sync): %v", err)
+		}
+	}
+	label := progress.label()
+	if label == "" {
+		label = "progress cleared"
+	}
+	fmt.Printf("'%s': %s 📖\n", read.Title, label)
+}
+
+// serveProgressInput previews the progress typed for the book in
+// current_bookID before -progress records it (-progressInput)
+func serveProgressInput(input string) {
+	bookID, err := strconv.Atoi(os.Getenv("current_bookID"))
+	if err != nil {
+		serveErrorItem("No book selected", err)
+		return
+	}
+	read, err := loadCurrentRead(bookID)
+	if err != nil {
+		serveErrorItem("Cannot read the reading progress", err)
+		return
+	}
+	if read.StatusID != 2 {
+		serveErrorItem("Not a book you are reading", fmt.Errorf("'%s' is not in Reading", read.Title))
+		return
+	}
+
+	item := map[string]interface{}{
+		"title":    "Type a page (123), a percentage (45%) or a listening time (2h13m)",
+		"subtitle": read.Title,
+		"valid":    false,
+		"icon": map[string]string{
+			"path": ReadStatusIcon[2],
+		},
+	}
+	if current := read.Progress.label(); current != "" {
+		item["subtitle"] = fmt.Sprintf("%s · currently %s", read.Title, current)
+	}
+	if strings.TrimSpace(input) != "" {
+		progress, err := parseProgress(input, read.Progress)
+		if err != nil {
+			item["title"] = err.Error()
+		} else {
+			label := progress.label()
+			if label == "" {
+				label = "No progress"
+			}
+			item["title"] = label
+			item["subtitle"] = fmt.Sprintf("↩️ to save the progress of '%s'", read.Title)
+			item["valid"] = true
+			item["arg"] = input
+		}
+	}
+
+	jsonData, err := json.MarshalIndent(map[string]interface{}{"items": []map[string]interface{}{item}}, "", "  ")
+	if err != nil {
+		LogF("Error encoding JSON: %v", err)
+		return
+	}
+	fmt.Println(string(jsonData))
+}
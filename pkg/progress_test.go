@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestParseProgress(t *testing.T) {
+	book := readingProgress{TotalPages: 480, TotalSeconds: 36300}
+	audiobook := readingProgress{Seconds: 600, TotalPages: 480, TotalSeconds: 36300}
+	tests := []struct {
+		input   string
+		current readingProgress
+		pages   int
+		seconds int
+	}{
+		{"123", book, 123, 0},
+		{"p. 123", book, 123, 0},
+		{"25%", book, 120, 0},
+		{"25%", audiobook, 0, 9075},
+		{"50%", readingProgress{TotalSeconds: 3600}, 0, 1800},
+		{"2h13m", book, 0, 7980},
+		{"45m", book, 0, 2700},
+		{"1:05", book, 0, 3900},
+	}
+	for _, test := range tests {
+		got, err := parseProgress(test.input, test.current)
+		if err != nil {
+			t.Errorf("parseProgress(%q): %v", test.input, err)
+			continue
+		}
+		if got.Pages != test.pages || got.Seconds != test.seconds {
+			t.Errorf("parseProgress(%q) = p. %d, %ds; want p. %d, %ds", test.input, got.Pages, got.Seconds, test.pages, test.seconds)
+		}
+	}
+
+	for _, input := range []string{"500", "120%", "11h", "soon", "", "30%"} {
+		current := book
+		if input == "30%" {
+			current = readingProgress{}
+		}
+		if _, err := parseProgress(input, current); err == nil {
+			t.Errorf("parseProgress(%q) accepted", input)
+		}
+	}
+}
+
+func TestProgressLabel(t *testing.T) {
+	tests := []struct {
+		progress readingProgress
+		want     string
+	}{
+		{readingProgress{Pages: 123, TotalPages: 480}, "p. 123/480 (26%)"},
+		{readingProgress{Pages: 123}, "p. 123"},
+		{readingProgress{Seconds: 7980, TotalSeconds: 36300}, "2h13m/10h05m (22%)"},
+		{readingProgress{Seconds: 2700}, "45m"},
+		{readingProgress{TotalPages: 480}, ""},
+	}
+	for _, test := range tests {
+		if got := test.progress.label(); got != test.want {
+			t.Errorf("label(%+v) = %q, want %q", test.progress, got, test.want)
+		}
+	}
+}
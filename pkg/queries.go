@@ -0,0 +1,325 @@
+package main
+
+// GraphQL documents sent to the Hardcover API.
+// User input and IDs are never pasted into these strings: every value travels
+// in the request variables (see newGraphQLRequest).
+
+// graphQLVars holds the variables attached to a GraphQL request
+type graphQLVars map[string]interface{}
+
+// newGraphQLRequest pairs a query document with its variables
+func newGraphQLRequest(query string, variables graphQLVars) GraphQLRequest {
+	return GraphQLRequest{
+		Query:     query,
+		Variables: variables,
+	}
+}
+
+const meQuery = `query Me {
+	me {
+		id
+		username
+		updated_at
+	}
+}`
+
+const userShelvesQuery = `query UserShelves {
+	me {
+		lists {
+			name
+			id
+			books_count
+			public
+			slug
+		}
+	}
+}`
+
+// userBookFields is the part of a user_book stored in the local library
+const userBookFields = `
+fragment UserBookFields on user_books {
+	book {
+		id
+		title
+		rating
+		cached_image
+		cached_contributors
+		release_year
+		ratings_count
+		slug
+		description
+		pages
+		audio_seconds
+	}
+	id
+	status_id
+	rating
+	date_added
+	user_book_reads {
+		id
+		started_at
+		finished_at
+		progress_pages
+		progress_seconds
+	}
+	edition {
+		isbn_10
+		isbn_13
+		pages
+		audio_seconds
+	}
+}`
+
+// listBookFields is the part of a list_book stored in the local library
+const listBookFields = `
+fragment ListBookFields on list_books {
+	id
+	book_id
+	date_added
+	list {
+		id
+		name
+	}
+	book {
+		cached_contributors
+		title
+		release_year
+		cached_image
+		rating
+		ratings_count
+		slug
+		description
+	}
+}`
+
+const libraryBooksQuery = `query LibraryBooks($userID: Int!, $limit: Int!, $offset: Int!) {
+	user_books(where: {user_id: {_eq: $userID}}, limit: $limit, offset: $offset, order_by: {id: asc}) {
+		...UserBookFields
+	}
+}` + userBookFields
+
+const shelfBooksQuery = `query ShelfBooks($userID: Int!, $limit: Int!, $offset: Int!) {
+	list_books(where: {list: {user_id: {_eq: $userID}}}, limit: $limit, offset: $offset, order_by: {id: asc}) {
+		...ListBookFields
+		user_books(where: {user_id: {_eq: $userID}}) {
+			id
+		}
+	}
+}` + listBookFields
+
+const changedUserBooksQuery = `query ChangedUserBooks($userID: Int!, $since: timestamptz!, $limit: Int!, $offset: Int!) {
+	user_books(where: {user_id: {_eq: $userID}, updated_at: {_gt: $since}}, limit: $limit, offset: $offset, order_by: {id: asc}) {
+		...UserBookFields
+	}
+}` + userBookFields
+
+const changedShelfBooksQuery = `query ChangedShelfBooks($userID: Int!, $since: timestamptz!, $limit: Int!, $offset: Int!) {
+	list_books(where: {list: {user_id: {_eq: $userID}}, updated_at: {_gt: $since}}, limit: $limit, offset: $offset, order_by: {id: asc}) {
+		...ListBookFields
+		user_books(where: {user_id: {_eq: $userID}}) {
+			id
+		}
+	}
+}` + listBookFields
+
+const changedReadsQuery = `query ChangedReads($userID: Int!, $since: timestamptz!) {
+	user_book_reads(where: {user_book: {user_id: {_eq: $userID}}, updated_at: {_gt: $since}}) {
+		id
+		user_book_id
+		started_at
+		finished_at
+		progress_pages
+		progress_seconds
+	}
+}`
+
+// libraryIDsQuery lists what still exists remotely, to find deleted rows
+const libraryIDsQuery = `query LibraryIDs($userID: Int!, $limit: Int!, $offset: Int!) {
+	user_books(where: {user_id: {_eq: $userID}}, limit: $limit, offset: $offset, order_by: {id: asc}) {
+		id
+		user_book_reads {
+			id
+		}
+	}
+}`
+
+const shelfIDsQuery = `query ShelfIDs($userID: Int!, $limit: Int!, $offset: Int!) {
+	list_books(where: {list: {user_id: {_eq: $userID}}}, limit: $limit, offset: $offset, order_by: {id: asc}) {
+		id
+	}
+}`
+
+// catalogSearchQuery searches books, authors, series, lists or users
+// ($queryType, see catalogKinds)
+const catalogSearchQuery = `query CatalogSearch($query: String!, $queryType: String!, $perPage: Int!, $page: Int!) {
+	search(query: $query, query_type: $queryType, per_page: $perPage, page: $page) {
+		results
+	}
+}`
+
+// catalogBookFields is the part of a book shown in catalog search
+const catalogBookFields = `
+fragment CatalogBookFields on books {
+	id
+	title
+	description
+	rating
+	ratings_count
+	release_year
+	slug
+	cached_image
+	cached_contributors
+}`
+
+// editionByISBNQuery finds the book of an edition from its ISBN-13 or
+// ISBN-10 (see isbnVariants)
+const editionByISBNQuery = `query EditionByISBN($isbns: [String!]!) {
+	editions(where: {_or: [{isbn_13: {_in: $isbns}}, {isbn_10: {_in: $isbns}}]}, limit: 1) {
+		book {
+			...CatalogBookFields
+		}
+	}
+}` + catalogBookFields
+
+const bookBySlugQuery = `query BookBySlug($slug: String!) {
+	books(where: {slug: {_eq: $slug}}, limit: 1) {
+		...CatalogBookFields
+	}
+}` + catalogBookFields
+
+const authorBooksQuery = `query AuthorBooks($authorID: Int!, $limit: Int!) {
+	books(where: {contributions: {author_id: {_eq: $authorID}}}, order_by: {users_count: desc}, limit: $limit) {
+		...CatalogBookFields
+	}
+}` + catalogBookFields
+
+// seriesBooksQuery lists a series in reading order, the most read book of
+// each position first
+const seriesBooksQuery = `query SeriesBooks($seriesID: Int!) {
+	book_series(where: {series_id: {_eq: $seriesID}}, order_by: [{position: asc_nulls_last}, {book: {users_count: desc}}]) {
+		position
+		book {
+			...CatalogBookFields
+		}
+	}
+}` + catalogBookFields
+
+const listBooksQuery = `query ListBooks($listID: Int!, $limit: Int!) {
+	list_books(where: {list_id: {_eq: $listID}}, order_by: {position: asc}, limit: $limit) {
+		book {
+			...CatalogBookFields
+		}
+	}
+}` + catalogBookFields
+
+const userListsQuery = `query UserLists($userID: Int!) {
+	lists(where: {user_id: {_eq: $userID}, public: {_eq: true}}, order_by: {updated_at: desc}) {
+		id
+		name
+		slug
+		description
+		books_count
+		user {
+			username
+		}
+	}
+}`
+
+const insertUserBookMutation = `mutation InsertUserBook($object: UserBookCreateInput!) {
+	insert_user_book(object: $object) {
+		id
+		error
+		user_book {
+			id
+			status_id
+			rating
+			book {
+				id
+			}
+		}
+	}
+}`
+
+const updateUserBookMutation = `mutation UpdateUserBook($id: Int!, $object: UserBookUpdateInput!) {
+	update_user_book(id: $id, object: $object) {
+		id
+		error
+		user_book {
+			id
+			status_id
+			rating
+			book {
+				id
+			}
+		}
+	}
+}`
+
+// userBookReadFields is the part of a user_book_read stored in the journey
+// table
+const userBookReadFields = `
+		user_book_read {
+			id
+			started_at
+			finished_at
+			progress_pages
+			progress_seconds
+		}`
+
+const userBookReadsQuery = `query UserBookReads($userBookID: Int!) {
+	user_book_reads(where: {user_book_id: {_eq: $userBookID}}, order_by: {id: asc}) {
+		id
+		started_at
+		finished_at
+		progress_pages
+		progress_seconds
+	}
+}`
+
+const insertUserBookReadMutation = `mutation InsertUserBookRead($userBookID: Int!, $object: DatesReadInput!) {
+	insert_user_book_read(user_book_id: $userBookID, user_book_read: $object) {
+		id
+		error` + userBookReadFields + `
+	}
+}`
+
+const updateUserBookReadMutation = `mutation UpdateUserBookRead($id: Int!, $object: DatesReadInput!) {
+	update_user_book_read(id: $id, object: $object) {
+		id
+		error` + userBookReadFields + `
+	}
+}`
+
+const deleteUserBookMutation = `mutation DeleteUserBook($id: Int!) {
+	delete_user_book(id: $id) {
+		book_id
+	}
+}`
+
+const userBookByIDQuery = `query UserBookByID($id: Int!) {
+	user_books_by_pk(id: $id) {
+		...UserBookFields
+	}
+}` + userBookFields
+
+const listBookByIDQuery = `query ListBookByID($id: Int!, $userID: Int!) {
+	list_books_by_pk(id: $id) {
+		...ListBookFields
+		user_books(where: {user_id: {_eq: $userID}}) {
+			id
+		}
+	}
+}` + listBookFields
+
+const insertListBookMutation = `mutation InsertListBook($object: ListBookInput!) {
+	insert_list_book(object: $object) {
+		id
+	}
+}`
+
+const deleteListBookMutation = `mutation DeleteListBook($id: Int!) {
+	delete_list_book(id: $id) {
+		id
+		list_id
+	}
+}`
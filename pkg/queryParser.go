@@ -0,0 +1,422 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Library query language (-library):
+//
+//	tolkien hobbit            words: prefix match on title and authors
+//	"the two towers"          quoted words: phrase match
+//	author:"le guin"          title:dune   shelf:favorites
+//	year:1990..2000           year:>=1990  year:..1950
+//	rating:>=4                your rating
+//	community:>4.2            Hardcover average rating
+//	isbn:978-0-261-10236-9    ISBN-10 or ISBN-13
+//	@reading                  reading status
+//	#biographies  #"sci fi"   shelf, picked from a list while typing
+//	-word  -author:x  ...     any term can be negated
+//
+// Terms are AND-combined and compiled to SQL with placeholders: nothing typed
+// by the user is pasted into the query text.
+
+// queryField names the filter a term applies to
+type queryField string
+
+const (
+	fieldText      queryField = ""
+	fieldAuthor    queryField = "author"
+	fieldTitle     queryField = "title"
+	fieldShelf     queryField = "shelf"
+	fieldYear      queryField = "year"
+	fieldRating    queryField = "rating"
+	fieldCommunity queryField = "community"
+	fieldISBN      queryField = "isbn"
+	fieldStatus    queryField = "status"
+	fieldShelfTag  queryField = "#"
+)
+
+var queryFields = map[string]queryField{
+	"author":    fieldAuthor,
+	"title":     fieldTitle,
+	"shelf":     fieldShelf,
+	"year":      fieldYear,
+	"rating":    fieldRating,
+	"community": fieldCommunity,
+	"isbn":      fieldISBN,
+}
+
+// numericColumns are the books columns behind the numeric fields
+var numericColumns = map[queryField]string{
+	fieldYear:      "b.release_year",
+	fieldRating:    "IFNULL(b.user_rating, 0)",
+	fieldCommunity: "IFNULL(b.rating, 0)",
+}
+
+// queryTerm is one parsed term of a library query
+type queryTerm struct {
+	Field      queryField
+	Value      string
+	Quoted     bool
+	Negated    bool
+	Conditions []numericCondition // numeric fields only
+	StatusID   int                // status field only
+	ShelfID    int                // shelf tags only, set by resolveShelfTags
+	Token      string             // shelf tags only, as typed
+}
+
+// numericCondition is a single comparison such as ">= 4"
+type numericCondition struct {
+	Op    string
+	Value float64
+}
+
+// libraryQuery is a parsed library query
+type libraryQuery struct {
+	Terms []queryTerm
+	// Text keeps the tokens other than @status as typed
+	Text []string
+	// StatusFragment is an @ token that does not name a status (yet)
+	StatusFragment string
+	StatusPending  bool
+	// ShelfFragment is a # token that does not name a shelf (yet)
+	ShelfFragment string
+	ShelfToken    string
+	ShelfPending  bool
+}
+
+// rawToken is a token as split from the input
+type rawToken struct {
+	text   string // as typed, quotes included
+	value  string // quotes removed
+	quoted bool
+}
+
+// splitQueryTokens splits on whitespace outside double quotes. An
+// unterminated quote runs to the end of the input, so half-typed queries
+// still parse.
+func splitQueryTokens(input string) []rawToken {
+	var tokens []rawToken
+	var text, value strings.Builder
+	inQuotes, quoted, started := false, false, false
+
+	flush := func() {
+		if started {
+			tokens = append(tokens, rawToken{text: text.String(), value: value.String(), quoted: quoted})
+		}
+		text.Reset()
+		value.Reset()
+		inQuotes, quoted, started = false, false, false
+	}
+
+	for _, r := range input {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			quoted = true
+			started = true
+			text.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuotes:
+			flush()
+		default:
+			started = true
+			text.WriteRune(r)
+			value.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// parseLibraryQuery parses the text typed after the library keyword. A
+// filter at the end of the input that does not parse yet (rating:>=) is
+// still being typed and is dropped, unless a space follows it.
+func parseLibraryQuery(input string) (libraryQuery, error) {
+	var query libraryQuery
+
+	tokens := splitQueryTokens(input)
+	typing := strings.TrimRightFunc(input, unicode.IsSpace) == input
+	for i, token := range tokens {
+		stillTyping := typing && i == len(tokens)-1
+
+		body := token.value
+		negated := false
+		if strings.HasPrefix(token.text, "-") {
+			negated = true
+			body = strings.TrimPrefix(body, "-")
+		}
+
+		// @status
+		if strings.HasPrefix(body, "@") && !token.quoted {
+			fragment := strings.TrimPrefix(body, "@")
+			statusID := statusIDByName(fragment)
+			if statusID == 0 {
+				query.StatusFragment = fragment
+				query.StatusPending = true
+				continue
+			}
+			query.Terms = append(query.Terms, queryTerm{Field: fieldStatus, Value: fragment, Negated: negated, StatusID: statusID})
+			continue
+		}
+
+		query.Text = append(query.Text, token.text)
+
+		// #shelf: resolved against the database later, even when still empty
+		if strings.HasPrefix(body, "#") {
+			query.Terms = append(query.Terms, queryTerm{
+				Field:   fieldShelfTag,
+				Value:   strings.TrimPrefix(body, "#"),
+				Quoted:  token.quoted,
+				Negated: negated,
+				Token:   token.text,
+			})
+			continue
+		}
+
+		term := queryTerm{Field: fieldText, Value: body, Quoted: token.quoted, Negated: negated}
+		if name, value, found := strings.Cut(body, ":"); found {
+			if field, known := queryFields[strings.ToLower(name)]; known {
+				term.Field = field
+				term.Value = value
+			}
+		}
+		if term.Value == "" {
+			// a lone "-" or a field still being typed
+			continue
+		}
+		if isTextField(term.Field) && normalizeSearchText(term.Value) == "" {
+			// punctuation only: nothing to search for
+			continue
+		}
+
+		switch term.Field {
+		case fieldYear, fieldRating, fieldCommunity:
+			conditions, err := parseNumericFilter(term.Value)
+			if err != nil && stillTyping {
+				continue
+			}
+			if err != nil {
+				return query, fmt.Errorf("%s: %w", term.Field, err)
+			}
+			term.Conditions = conditions
+		case fieldISBN:
+			isbn := normalizeISBN(term.Value)
+			if isbn == "" && stillTyping {
+				continue
+			}
+			if isbn == "" {
+				return query, fmt.Errorf("isbn: %q is not an ISBN", term.Value)
+			}
+			term.Value = isbn
+		}
+		query.Terms = append(query.Terms, term)
+	}
+	return query, nil
+}
+
+// isTextField reports the fields matched against the full-text index
+func isTextField(field queryField) bool {
+	return field == fieldText || field == fieldAuthor || field == fieldTitle
+}
+
+// statusIDByName returns the status called name, 0 if there is none
+func statusIDByName(name string) int {
+	for id, status := range ReadStatus {
+		if strings.EqualFold(status, name) {
+			return id
+		}
+	}
+	return 0
+}
+
+// parseNumericFilter reads "a..b", "a..", "..b", ">=a", "<a", "=a" or "a"
+func parseNumericFilter(value string) ([]numericCondition, error) {
+	number := func(text string) (float64, error) {
+		n, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", text)
+		}
+		return n, nil
+	}
+
+	if low, high, isRange := strings.Cut(value, ".."); isRange {
+		var conditions []numericCondition
+		if low != "" {
+			n, err := number(low)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, numericCondition{">=", n})
+		}
+		if high != "" {
+			n, err := number(high)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, numericCondition{"<=", n})
+		}
+		if len(conditions) == 0 {
+			return nil, fmt.Errorf("empty range")
+		}
+		return conditions, nil
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			n, err := number(strings.TrimPrefix(value, op))
+			if err != nil {
+				return nil, err
+			}
+			return []numericCondition{{op, n}}, nil
+		}
+	}
+
+	n, err := number(value)
+	if err != nil {
+		return nil, err
+	}
+	return []numericCondition{{"=", n}}, nil
+}
+
+// normalizeISBN strips separators, returning "" for anything but 10 or 13
+// digits (an ISBN-10 may end in X)
+func normalizeISBN(value string) string {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(value))
+	if len(isbn) != 10 && len(isbn) != 13 {
+		return ""
+	}
+	for i, r := range isbn {
+		if r >= '0' && r <= '9' {
+			continue
+		}
+		if r == 'X' && len(isbn) == 10 && i == 9 {
+			continue
+		}
+		return ""
+	}
+	return isbn
+}
+
+// ftsPhrase quotes a value for an FTS5 query; unquoted values match as a
+// prefix of their last word
+func ftsPhrase(term queryTerm) string {
+	// normalized like the indexed text (see searchText.go)
+	phrase := `"` + strings.ReplaceAll(normalizeSearchText(term.Value), `"`, `""`) + `"`
+	if !term.Quoted {
+		phrase += " *"
+	}
+	switch term.Field {
+	case fieldAuthor:
+		return "authors : " + phrase
+	case fieldTitle:
+		return "title : " + phrase
+	}
+	return phrase
+}
+
+// escapeLike escapes the LIKE wildcards of a value (ESCAPE '\')
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// compile turns the query into WHERE clauses (books b, books_authors_fts f)
+// and their arguments
+func (q libraryQuery) compile() ([]string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	var matches []string
+
+	for _, term := range q.Terms {
+		var clause string
+		var termArgs []interface{}
+
+		switch term.Field {
+		case fieldText, fieldAuthor, fieldTitle:
+			if !term.Negated {
+				// positive full-text terms share a single MATCH
+				matches = append(matches, ftsPhrase(term))
+				continue
+			}
+			clauses = append(clauses, "b.book_id NOT IN (SELECT book_id FROM books_authors_fts WHERE books_authors_fts MATCH ?)")
+			args = append(args, ftsPhrase(term))
+			continue
+
+		case fieldShelf:
+			clause = `EXISTS (SELECT 1 FROM shelf qs WHERE qs.user_book_id = b.user_book_id AND qs.name LIKE ? ESCAPE '\')`
+			termArgs = append(termArgs, escapeLike(term.Value)+"%")
+
+		case fieldYear, fieldRating, fieldCommunity:
+			var comparisons []string
+			for _, condition := range term.Conditions {
+				comparisons = append(comparisons, numericColumns[term.Field]+" "+condition.Op+" ?")
+				termArgs = append(termArgs, condition.Value)
+			}
+			clause = strings.Join(comparisons, " AND ")
+
+		case fieldISBN:
+			clause = "b.isbn_13 = ? OR b.isbn_10 = ?"
+			termArgs = append(termArgs, term.Value, term.Value)
+
+		case fieldStatus:
+			clause = "b.status_id = ?"
+			termArgs = append(termArgs, term.StatusID)
+
+		case fieldShelfTag:
+			clause = "EXISTS (SELECT 1 FROM shelf qs WHERE qs.user_book_id = b.user_book_id AND qs.shelf_id = ?)"
+			termArgs = append(termArgs, term.ShelfID)
+		}
+
+		if term.Negated {
+			clause = "NOT (" + clause + ")"
+		} else {
+			clause = "(" + clause + ")"
+		}
+		clauses = append(clauses, clause)
+		args = append(args, termArgs...)
+	}
+
+	if len(matches) > 0 {
+		clauses = append([]string{ftsMatchClause}, clauses...)
+		args = append([]interface{}{strings.Join(matches, " ")}, args...)
+	}
+	return clauses, args
+}
+
+// resolveShelfTags looks up the shelf named by each # token. A token that
+// names no shelf is dropped from the filters and becomes the ShelfFragment
+// the picker completes.
+func (q *libraryQuery) resolveShelfTags(db sqlExecutor) error {
+	var resolved []queryTerm
+	for _, term := range q.Terms {
+		if term.Field != fieldShelfTag {
+			resolved = append(resolved, term)
+			continue
+		}
+
+		err := db.QueryRow(`SELECT shelf_id FROM bookshelves WHERE name = ? COLLATE NOCASE`, term.Value).Scan(&term.ShelfID)
+		if err == sql.ErrNoRows {
+			q.ShelfFragment = term.Value
+			q.ShelfToken = term.Token
+			q.ShelfPending = true
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up shelf %q: %w", term.Value, err)
+		}
+		resolved = append(resolved, term)
+	}
+	q.Terms = resolved
+	return nil
+}
+
+// shelfTagToken is the # token inserted for a shelf
+func shelfTagToken(name string) string {
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return `#"` + name + `"`
+	}
+	return "#" + name
+}
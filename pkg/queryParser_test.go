@@ -0,0 +1,240 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitQueryTokens(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []rawToken
+	}{
+		{"", nil},
+		{"  dune  herbert ", []rawToken{{text: "dune", value: "dune"}, {text: "herbert", value: "herbert"}}},
+		{`author:"le guin" earthsea`, []rawToken{
+			{text: `author:"le guin"`, value: "author:le guin", quoted: true},
+			{text: "earthsea", value: "earthsea"},
+		}},
+		{`"the two towers"`, []rawToken{{text: `"the two towers"`, value: "the two towers", quoted: true}}},
+		// an unterminated quote runs to the end
+		{`title:"the lord of`, []rawToken{{text: `title:"the lord of`, value: "title:the lord of", quoted: true}}},
+	}
+
+	for _, test := range tests {
+		got := splitQueryTokens(test.input)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitQueryTokens(%q) = %+v, want %+v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseNumericFilter(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []numericCondition
+	}{
+		{"1990..2000", []numericCondition{{">=", 1990}, {"<=", 2000}}},
+		{"1990..", []numericCondition{{">=", 1990}}},
+		{"..1950", []numericCondition{{"<=", 1950}}},
+		{">=4", []numericCondition{{">=", 4}}},
+		{"<=2.5", []numericCondition{{"<=", 2.5}}},
+		{">4.2", []numericCondition{{">", 4.2}}},
+		{"<3", []numericCondition{{"<", 3}}},
+		{"=5", []numericCondition{{"=", 5}}},
+		{"1984", []numericCondition{{"=", 1984}}},
+	}
+	for _, test := range tests {
+		got, err := parseNumericFilter(test.input)
+		if err != nil {
+			t.Errorf("parseNumericFilter(%q) returned error: %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseNumericFilter(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+
+	for _, input := range []string{"..", ">=", "abc", "19..x"} {
+		if _, err := parseNumericFilter(input); err == nil {
+			t.Errorf("parseNumericFilter(%q) should fail", input)
+		}
+	}
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := map[string]string{
+		"978-0-261-10236-9": "9780261102369",
+		"0 261 10236 X":     "026110236X",
+		"026110236x":        "026110236X",
+		"12345":             "",
+		"97802611023AB":     "",
+		"02611X2369":        "",
+	}
+	for input, want := range tests {
+		if got := normalizeISBN(input); got != want {
+			t.Errorf("normalizeISBN(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestParseLibraryQuery(t *testing.T) {
+	query, err := parseLibraryQuery(`hobbit -author:"le guin" year:1990..2000 Rating:>=4 isbn:978-0-261-10236-9 @Read shelf:fav -x:y -`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []queryTerm{
+		{Field: fieldText, Value: "hobbit"},
+		{Field: fieldAuthor, Value: "le guin", Quoted: true, Negated: true},
+		{Field: fieldYear, Value: "1990..2000", Conditions: []numericCondition{{">=", 1990}, {"<=", 2000}}},
+		{Field: fieldRating, Value: ">=4", Conditions: []numericCondition{{">=", 4}}},
+		{Field: fieldISBN, Value: "9780261102369"},
+		{Field: fieldStatus, Value: "Read", StatusID: 3},
+		{Field: fieldShelf, Value: "fav"},
+		// unknown fields are plain words
+		{Field: fieldText, Value: "x:y", Negated: true},
+	}
+	if !reflect.DeepEqual(query.Terms, want) {
+		t.Errorf("terms = %+v\nwant   %+v", query.Terms, want)
+	}
+	if query.StatusPending {
+		t.Errorf("status should not be pending")
+	}
+	wantText := []string{"hobbit", `-author:"le guin"`, "year:1990..2000", "Rating:>=4", "isbn:978-0-261-10236-9", "shelf:fav", "-x:y", "-"}
+	if !reflect.DeepEqual(query.Text, wantText) {
+		t.Errorf("text = %q, want %q", query.Text, wantText)
+	}
+}
+
+func TestParseLibraryQueryPartial(t *testing.T) {
+	// fields still being typed are ignored, an unknown @ opens the status picker
+	query, err := parseLibraryQuery("dune author: @rea")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(query.Terms) != 1 || query.Terms[0].Value != "dune" {
+		t.Errorf("terms = %+v, want only dune", query.Terms)
+	}
+	if !query.StatusPending || query.StatusFragment != "rea" {
+		t.Errorf("status fragment = %q (pending %v), want rea", query.StatusFragment, query.StatusPending)
+	}
+
+	// a filter that does not parse yet is dropped while it is typed, and
+	// fails once a space follows it
+	for _, input := range []string{"rating:>", "rating:>=", "year:abc", "community:..x", "isbn:123"} {
+		query, err := parseLibraryQuery("dune " + input)
+		if err != nil || len(query.Terms) != 1 {
+			t.Errorf("parseLibraryQuery(%q) = %+v, %v; want only dune", "dune "+input, query.Terms, err)
+		}
+		if _, err := parseLibraryQuery(input + " "); err == nil {
+			t.Errorf("parseLibraryQuery(%q) should fail", input+" ")
+		}
+	}
+	if _, err := parseLibraryQuery("rating:> dune"); err == nil {
+		t.Errorf("an invalid filter before the last term should fail")
+	}
+}
+
+func TestCompileLibraryQuery(t *testing.T) {
+	tests := []struct {
+		input   string
+		clauses []string
+		args    []interface{}
+	}{
+		{
+			input:   "",
+			clauses: nil,
+			args:    nil,
+		},
+		{
+			input:   `tolk "the hobbit" author:tolkien title:"two towers"`,
+			clauses: []string{"(books_authors_fts MATCH ?)"},
+			args:    []interface{}{`"tolk" * "the hobbit" authors : "tolkien" * title : "two towers"`},
+		},
+		{
+			input: `-author:rowling community:>4.2 -shelf:50%`,
+			clauses: []string{
+				"b.book_id NOT IN (SELECT book_id FROM books_authors_fts WHERE books_authors_fts MATCH ?)",
+				"(IFNULL(b.rating, 0) > ?)",
+				`NOT (EXISTS (SELECT 1 FROM shelf qs WHERE qs.user_book_id = b.user_book_id AND qs.name LIKE ? ESCAPE '\'))`,
+			},
+			args: []interface{}{`authors : "rowling" *`, 4.2, `50\%%`},
+		},
+		{
+			input: `year:1990..2000 -rating:<3 isbn:026110236X dune @reading`,
+			clauses: []string{
+				"(books_authors_fts MATCH ?)",
+				"(b.release_year >= ? AND b.release_year <= ?)",
+				"NOT (IFNULL(b.user_rating, 0) < ?)",
+				"(b.isbn_13 = ? OR b.isbn_10 = ?)",
+				"(b.status_id = ?)",
+			},
+			args: []interface{}{`"dune" *`, 1990.0, 2000.0, 3.0, "026110236X", "026110236X", 2},
+		},
+		{
+			// a stray quote makes the value a phrase and cannot break out of it
+			input:   `title:say"hi`,
+			clauses: []string{"(books_authors_fts MATCH ?)"},
+			args:    []interface{}{`title : "sayhi"`},
+		},
+	}
+
+	for _, test := range tests {
+		query, err := parseLibraryQuery(test.input)
+		if err != nil {
+			t.Errorf("parseLibraryQuery(%q) returned error: %v", test.input, err)
+			continue
+		}
+		clauses, args := query.compile()
+		if !reflect.DeepEqual(clauses, test.clauses) {
+			t.Errorf("compile(%q) clauses = %q\nwant %q", test.input, clauses, test.clauses)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("compile(%q) args = %#v\nwant %#v", test.input, args, test.args)
+		}
+	}
+}
+
+func TestParseShelfTags(t *testing.T) {
+	query, err := parseLibraryQuery(`lincoln #Biographies -#"Science Fiction" #`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []queryTerm{
+		{Field: fieldText, Value: "lincoln"},
+		{Field: fieldShelfTag, Value: "Biographies", Token: "#Biographies"},
+		{Field: fieldShelfTag, Value: "Science Fiction", Quoted: true, Negated: true, Token: `-#"Science Fiction"`},
+		{Field: fieldShelfTag, Value: "", Token: "#"},
+	}
+	if !reflect.DeepEqual(query.Terms, want) {
+		t.Errorf("terms = %+v\nwant   %+v", query.Terms, want)
+	}
+
+	// resolved tags filter on shelf_id and combine with AND
+	query.Terms[1].ShelfID = 7
+	query.Terms[2].ShelfID = 9
+	query.Terms = query.Terms[:3]
+	clauses, args := query.compile()
+	wantClauses := []string{
+		"(books_authors_fts MATCH ?)",
+		"(EXISTS (SELECT 1 FROM shelf qs WHERE qs.user_book_id = b.user_book_id AND qs.shelf_id = ?))",
+		"NOT (EXISTS (SELECT 1 FROM shelf qs WHERE qs.user_book_id = b.user_book_id AND qs.shelf_id = ?))",
+	}
+	if !reflect.DeepEqual(clauses, wantClauses) {
+		t.Errorf("clauses = %q\nwant %q", clauses, wantClauses)
+	}
+	if wantArgs := []interface{}{`"lincoln" *`, 7, 9}; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestShelfTagToken(t *testing.T) {
+	if got := shelfTagToken("Biographies"); got != "#Biographies" {
+		t.Errorf("shelfTagToken(Biographies) = %q", got)
+	}
+	if got := shelfTagToken("Science Fiction"); got != `#"Science Fiction"` {
+		t.Errorf("shelfTagToken(Science Fiction) = %q", got)
+	}
+}
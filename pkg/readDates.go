@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Reads follow status changes: moving a book to Reading starts a read,
+// moving it to Read finishes the open read (or records a finished one). The
+// date is today unless -changeStatus is given another one: "yesterday" or
+// an ISO date.
+
+const readDateLayout = "2006-01-02"
+
+// parseReadDate turns the typed date of a status change into an ISO date
+func parseReadDate(input string, now time.Time) (string, error) {
+	switch value := strings.ToLower(strings.TrimSpace(input)); value {
+	case "", "today":
+		return now.Format(readDateLayout), nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1).Format(readDateLayout), nil
+	default:
+		date, err := time.ParseInLocation(readDateLayout, value, now.Location())
+		if err != nil {
+			return "", fmt.Errorf("%q is not a date: use today, yesterday or YYYY-MM-DD", input)
+		}
+		if date.After(now) {
+			return "", fmt.Errorf("%s is in the future", value)
+		}
+		return value, nil
+	}
+}
+
+// fetchUserBookReads downloads the reads of a user_book
+func fetchUserBookReads(userBookID int) ([]UserBookRead, error) {
+	var response struct {
+		Data struct {
+			Reads []UserBookRead `json:"user_book_reads"`
+		} `json:"data"`
+	}
+	body, err := interrogateAPI(newGraphQLRequest(userBookReadsQuery, graphQLVars{
+		"userBookID": userBookID,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error decoding reads: %w", err)
+	}
+	return response.Data.Reads, nil
+}
+
+// openRead returns the unfinished read started last, nil if there is none
+func openRead(reads []UserBookRead) *UserBookRead {
+	var open *UserBookRead
+	for i, read := range reads {
+		if read.FinishedAt != nil {
+			continue
+		}
+		if open == nil || readStart(read) > readStart(*open) ||
+			(readStart(read) == readStart(*open) && read.ID > open.ID) {
+			open = &reads[i]
+		}
+	}
+	return open
+}
+
+func readStart(read UserBookRead) string {
+	if read.StartedAt == nil {
+		return ""
+	}
+	return *read.StartedAt
+}
+
+// readInput is the DatesReadInput rewriting a read with its own values
+func readInput(read UserBookRead) map[string]interface{} {
+	return map[string]interface{}{
+		"started_at":       read.StartedAt,
+		"finished_at":      read.FinishedAt,
+		"progress_pages":   read.ProgressPages,
+		"progress_seconds": read.ProgressSeconds,
+	}
+}
+
+// recordStatusDates starts or finishes a read of a user_book for its new
+// status and describes what it did ("" when nothing was needed)
+func recordStatusDates(userBookID, statusID int, date string) (string, error) {
+	if statusID != 2 && statusID != 3 {
+		return "", nil
+	}
+	reads, err := fetchUserBookReads(userBookID)
+	if err != nil {
+		return "", err
+	}
+	open := openRead(reads)
+
+	var request GraphQLRequest
+	var mutationField, description string
+	switch {
+	case statusID == 2 && open != nil:
+		// already being read
+		return "", nil
+	case statusID == 2:
+		mutationField = "insert_user_book_read"
+		request = newGraphQLRequest(insertUserBookReadMutation, graphQLVars{
+			"userBookID": userBookID,
+			"object":     map[string]interface{}{"started_at": date},
+		})
+		description = "started " + date
+	case open != nil:
+		if started := readStart(*open); started != "" && date < firstDate(started) {
+			return "", fmt.Errorf("%s is before the start of the read (%s)", date, firstDate(started))
+		}
+		finished := *open
+		finished.FinishedAt = &date
+		mutationField = "update_user_book_read"
+		request = newGraphQLRequest(updateUserBookReadMutation, graphQLVars{
+			"id":     open.ID,
+			"object": readInput(finished),
+		})
+		description = "finished " + date
+	default:
+		mutationField = "insert_user_book_read"
+		request = newGraphQLRequest(insertUserBookReadMutation, graphQLVars{
+			"userBookID": userBookID,
+			"object":     map[string]interface{}{"finished_at": date},
+		})
+		description = "finished " + date
+	}
+
+	result, err := runMutation(request, mutationField)
+	if err != nil {
+		return "", err
+	}
+	if result.UserBookRead != nil {
+		if err := storeReadLocally(userBookID, *result.UserBookRead); err != nil {
+			LogF("Failed to update the local library (fixed at next sync): %v", err)
+		}
+	}
+	return description, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReadDate(t *testing.T) {
+	now := time.Date(2025, 3, 1, 22, 30, 0, 0, time.Local)
+	tests := map[string]string{
+		"":           "2025-03-01",
+		"today":      "2025-03-01",
+		" Yesterday": "2025-02-28",
+		"2024-12-31": "2024-12-31",
+	}
+	for input, want := range tests {
+		got, err := parseReadDate(input, now)
+		if err != nil || got != want {
+			t.Errorf("parseReadDate(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"2025-03-02", "31/12/2024", "last week"} {
+		if _, err := parseReadDate(input, now); err == nil {
+			t.Errorf("parseReadDate(%q) accepted", input)
+		}
+	}
+}
+
+func TestOpenRead(t *testing.T) {
+	date := func(s string) *string { return &s }
+	reads := []UserBookRead{
+		{ID: 1, StartedAt: date("2023-01-01"), FinishedAt: date("2023-02-01")},
+		{ID: 2, StartedAt: date("2024-05-01")},
+		{ID: 3},
+		{ID: 4, StartedAt: date("2024-04-01")},
+	}
+	if open := openRead(reads); open == nil || open.ID != 2 {
+		t.Errorf("openRead = %+v, want read 2", open)
+	}
+	if open := openRead(reads[:1]); open != nil {
+		t.Errorf("openRead of finished reads = %+v, want nil", open)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Re-reads. A book already read goes back to Reading with a new read
+// (-reread, its date picked with -rereadInput), the earlier reads kept; the
+// library counts the finished reads, and -history lists the reads of a book.
+
+// readCountColumn counts the finished reads of b
+const readCountColumn = `
+	(SELECT COUNT(*) FROM journey j WHERE j.user_book_id = b.user_book_id AND j.finished_at IS NOT NULL)`
+
+// readCountLabel is "read 3×", for books read more than once
+func readCountLabel(count int) string {
+	if count < 2 {
+		return ""
+	}
+	return fmt.Sprintf("read %d×", count)
+}
+
+// startReread puts the book in current_user_bookID back to Reading with a
+// new read, dated dateString or today (-reread); an open read, if any, is
+// the re-read already started
+func startReread(dateString string) {
+	userBookID, err := strconv.Atoi(os.Getenv("current_user_bookID"))
+	if err != nil || userBookID == 0 {
+		fmt.Fprintf(os.Stderr, "Error converting userBookID: %s", err)
+		fmt.Println("⚠️ Could not start a re-read: this book is not in your library.")
+		return
+	}
+	fmt.Println(setBookStatus(userBookID, 0, 2, dateString))
+}
+
+// serveRereadInput offers the start dates of a re-read: today, yesterday
+// or the date typed (-rereadInput)
+func serveRereadInput(input string) {
+	now := time.Now()
+	dates := []string{"today", "yesterday"}
+	if strings.TrimSpace(input) != "" {
+		dates = []string{input}
+	}
+
+	items := []map[string]interface{}{}
+	for _, date := range dates {
+		readDate, err := parseReadDate(date, now)
+		if err != nil {
+			serveErrorItem("Type the start of the re-read: today, yesterday or YYYY-MM-DD", err)
+			return
+		}
+		items = append(items, map[string]interface{}{
+			"title":    fmt.Sprintf("Re-read from %s", readDate),
+			"subtitle": "↩️ to put the book back to Reading with a new read",
+			"valid":    true,
+			"arg":      readDate,
+			"icon": map[string]string{
+				"path": ReadStatusIcon[2],
+			},
+		})
+	}
+
+	jsonData, err := json.MarshalIndent(map[string]interface{}{"items": items}, "", "  ")
+	if err != nil {
+		LogF("Error encoding JSON: %v", err)
+		return
+	}
+	fmt.Println(string(jsonData))
+}
+
+// bookRead is a read of the history view
+type bookRead struct {
+	StartedAt  string
+	FinishedAt string
+	Progress   readingProgress
+}
+
+// days is the length of a finished read in days, -1 when unknown
+func (r bookRead) days() int {
+	started, err := time.Parse(readDateLayout, firstDate(r.StartedAt))
+	if err != nil {
+		return -1
+	}
+	finished, err := time.Parse(readDateLayout, firstDate(r.FinishedAt))
+	if err != nil || finished.Before(started) {
+		return -1
+	}
+	return int(finished.Sub(started).Hours()/24) + 1
+}
+
+// firstDate keeps the date of a date or timestamp
+func firstDate(value string) string {
+	if len(value) > len(readDateLayout) {
+		return value[:len(readDateLayout)]
+	}
+	return value
+}
+
+// historyItem is the Alfred item of the n-th read
+func (r bookRead) historyItem(n int) map[string]interface{} {
+	var title, subtitle string
+	switch {
+	case r.FinishedAt == "":
+		title = fmt.Sprintf("Read %d · reading", n)
+		if r.StartedAt != "" {
+			title = fmt.Sprintf("Read %d · reading since %s", n, firstDate(r.StartedAt))
+		}
+		subtitle = r.Progress.label()
+	default:
+		title = fmt.Sprintf("Read %d · finished %s", n, firstDate(r.FinishedAt))
+		subtitle = "start date unknown"
+		if r.StartedAt != "" {
+			subtitle = "started " + firstDate(r.StartedAt)
+		}
+		if days := r.days(); days == 1 {
+			subtitle += " · 1 day"
+		} else if days > 1 {
+			subtitle += fmt.Sprintf(" · %d days", days)
+		}
+	}
+	icon := ReadStatusIcon[3]
+	if r.FinishedAt == "" {
+		icon = ReadStatusIcon[2]
+	}
+	return map[string]interface{}{
+		"title":    title,
+		"subtitle": subtitle,
+		"valid":    false,
+		"icon": map[string]string{
+			"path": icon,
+		},
+	}
+}
+
+// loadBookReads reads the reads of a library book, oldest first
+func loadBookReads(db *sql.DB, userBookID int) ([]bookRead, error) {
+	rows, err := db.Query(`SELECT IFNULL(j.started_at, ''), IFNULL(j.finished_at, ''),
+			IFNULL(j.progress_pages, 0), IFNULL(j.progress_seconds, 0), IFNULL(b.pages, 0), IFNULL(b.audio_seconds, 0)
+		FROM journey j JOIN books b ON b.user_book_id = j.user_book_id
+		WHERE j.user_book_id = ?
+		ORDER BY COALESCE(j.started_at, j.finished_at), j.journey_id`, userBookID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the reads: %w", err)
+	}
+	defer rows.Close()
+
+	var reads []bookRead
+	for rows.Next() {
+		var read bookRead
+		if err := rows.Scan(&read.StartedAt, &read.FinishedAt, &read.Progress.Pages, &read.Progress.Seconds,
+			&read.Progress.TotalPages, &read.Progress.TotalSeconds); err != nil {
+			return nil, fmt.Errorf("failed to scan read: %w", err)
+		}
+		reads = append(reads, read)
+	}
+	return reads, rows.Err()
+}
+
+// serveReadHistory lists the reads of the book in current_bookID (-history)
+func serveReadHistory() {
+	bookID, err := strconv.Atoi(os.Getenv("current_bookID"))
+	if err != nil {
+		serveErrorItem("No book selected", err)
+		return
+	}
+	db, err := openLibraryDatabase(databasePath)
+	if err != nil {
+		serveErrorItem("Cannot open your library", err)
+		return
+	}
+	defer db.Close()
+
+	var title string
+	var userBookID int
+	err = db.QueryRow(`SELECT title, user_book_id FROM books WHERE book_id = ? AND user_book_id > 0`, bookID).Scan(&title, &userBookID)
+	if err != nil {
+		serveErrorItem("This book is not in your library", err)
+		return
+	}
+	reads, err := loadBookReads(db, userBookID)
+	if err != nil {
+		serveErrorItem("Cannot read the reading history", err)
+		return
+	}
+
+	items := []map[string]interface{}{}
+	for i := len(reads) - 1; i >= 0; i-- {
+		item := reads[i].historyItem(i + 1)
+		if subtitle := item["subtitle"].(string); subtitle != "" {
+			item["subtitle"] = title + " · " + subtitle
+		} else {
+			item["subtitle"] = title
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		items = append(items, map[string]interface{}{
+			"title":    "No reads recorded",
+			"subtitle": title + ": changing its status to Reading or Read records one",
+			"valid":    false,
+			"icon": map[string]string{
+				"path": "icons/hopeless.png",
+			},
+		})
+	}
+
+	jsonData, err := json.MarshalIndent(map[string]interface{}{"items": items}, "", "  ")
+	if err != nil {
+		LogF("Error encoding JSON: %v", err)
+		return
+	}
+	fmt.Println(string(jsonData))
+}
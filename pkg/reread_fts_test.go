@@ -0,0 +1,36 @@
+//go:build sqlite_fts5 || fts5
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadBookReads reads the reads from the migrated library schema; it
+// needs SQLite with FTS5 (go test -tags sqlite_fts5)
+func TestLoadBookReads(t *testing.T) {
+	db, err := openLibraryDatabase(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, statement := range []string{
+		`INSERT INTO books (book_id, user_book_id, title, pages) VALUES (1, 10, 'Middlemarch', 300)`,
+		`INSERT INTO journey (journey_id, user_book_id, started_at, progress_pages) VALUES (7, 10, '2025-02-01', 120)`,
+		`INSERT INTO journey (journey_id, user_book_id, started_at, finished_at) VALUES (5, 10, '2020-01-01', '2020-02-01')`,
+		`INSERT INTO journey (journey_id, user_book_id, started_at) VALUES (6, 11, '2021-01-01')`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reads, err := loadBookReads(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reads) != 2 || reads[0].FinishedAt != "2020-02-01" || reads[1].Progress.label() != "p. 120/300 (40%)" {
+		t.Errorf("loadBookReads = %+v", reads)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReadCountLabel(t *testing.T) {
+	for count, want := range map[int]string{0: "", 1: "", 3: "read 3×"} {
+		if got := readCountLabel(count); got != want {
+			t.Errorf("readCountLabel(%d) = %q, want %q", count, got, want)
+		}
+	}
+}
+
+func TestHistoryItem(t *testing.T) {
+	finished := bookRead{StartedAt: "2024-05-01", FinishedAt: "2024-06-10T00:00:00Z"}
+	item := finished.historyItem(2)
+	if item["title"] != "Read 2 · finished 2024-06-10" || item["subtitle"] != "started 2024-05-01 · 41 days" {
+		t.Errorf("finished read = %q, %q", item["title"], item["subtitle"])
+	}
+	open := bookRead{StartedAt: "2025-01-03", Progress: readingProgress{Pages: 50, TotalPages: 200}}
+	item = open.historyItem(3)
+	if item["title"] != "Read 3 · reading since 2025-01-03" || item["subtitle"] != "p. 50/200 (25%)" {
+		t.Errorf("open read = %q, %q", item["title"], item["subtitle"])
+	}
+	if days := (bookRead{FinishedAt: "2024-06-10"}).days(); days != -1 {
+		t.Errorf("days without a start = %d, want -1", days)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// On-disk cache of catalog search results, in searchCache.db next to
+// books.db (rebuilding the library keeps it). An entry is the result set
+// shown for a query, a number of pages and a sort; going back to an earlier
+// search or reopening Alfred serves it without calling the API. Entries
+// older than SEARCH_CACHE_HOURS are refetched, and only serve when the API
+// cannot be reached; at most SEARCH_CACHE_ENTRIES are kept, least recently
+// used first out.
+
+const (
+	defaultSearchCacheHours   = 24
+	defaultSearchCacheEntries = 200
+)
+
+// searchCache is the open cache; its methods do nothing on a nil cache, so
+// a broken cache only costs API calls
+type searchCache struct {
+	db *sql.DB
+}
+
+// openSearchCache opens the cache database, creating it if needed
+func openSearchCache(path string) (*searchCache, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open search cache: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS search_cache (
+		query TEXT NOT NULL,
+		pages INTEGER NOT NULL,
+		sort TEXT NOT NULL,
+		books TEXT NOT NULL,      -- JSON array of BookSearch
+		fetched_at INTEGER NOT NULL,
+		used_at INTEGER NOT NULL,
+		PRIMARY KEY (query, pages, sort)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create search cache: %w", err)
+	}
+	return &searchCache{db: db}, nil
+}
+
+func (c *searchCache) Close() {
+	if c != nil {
+		c.db.Close()
+	}
+}
+
+// searchCacheKey normalizes a search: case and spacing do not matter
+func searchCacheKey(searchString string) string {
+	return strings.Join(strings.Fields(strings.ToLower(searchString)), " ")
+}
+
+// sortSpecsKey is the canonical form of sort flags, e.g. "y-,t+"
+func sortSpecsKey(specs []sortSpec) string {
+	var keys []string
+	for _, spec := range specs {
+		direction := "+"
+		if spec.Descending {
+			direction = "-"
+		}
+		keys = append(keys, spec.Key.Name+direction)
+	}
+	return strings.Join(keys, ",")
+}
+
+// Get returns the entry for a query, pages and sort. Expired entries are
+// only returned when stale is set.
+func (c *searchCache) Get(query string, pages int, sort string, stale bool) ([]BookSearch, bool) {
+	if c == nil {
+		return nil, false
+	}
+	oldest := time.Now().Add(-searchCacheTTL).Unix()
+	if stale {
+		oldest = 0
+	}
+	var booksJSON string
+	err := c.db.QueryRow(`SELECT books FROM search_cache
+		WHERE query = ? AND pages = ? AND sort = ? AND fetched_at >= ?`,
+		query, pages, sort, oldest).Scan(&booksJSON)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			LogF("Search cache read failed: %v", err)
+		}
+		return nil, false
+	}
+	books, ok := decodeCachedBooks(booksJSON)
+	if ok {
+		c.touch(query, pages, sort)
+	}
+	return books, ok
+}
+
+// Closest returns the fresh entry of a query with the most pages, up to
+// pages, whatever its sort: sorting is done locally, so the books can be
+// re-sorted and more pages appended. It also returns the pages the entry
+// holds (0 when there is none) and when it was fetched.
+func (c *searchCache) Closest(query string, pages int) ([]BookSearch, int, time.Time) {
+	if c == nil {
+		return nil, 0, time.Time{}
+	}
+	var booksJSON string
+	var cachedPages int
+	var fetchedAt int64
+	err := c.db.QueryRow(`SELECT books, pages, fetched_at FROM search_cache
+		WHERE query = ? AND pages <= ? AND fetched_at >= ?
+		ORDER BY pages DESC, fetched_at DESC LIMIT 1`,
+		query, pages, time.Now().Add(-searchCacheTTL).Unix()).Scan(&booksJSON, &cachedPages, &fetchedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			LogF("Search cache read failed: %v", err)
+		}
+		return nil, 0, time.Time{}
+	}
+	books, ok := decodeCachedBooks(booksJSON)
+	if !ok {
+		return nil, 0, time.Time{}
+	}
+	return books, cachedPages, time.Unix(fetchedAt, 0)
+}
+
+func decodeCachedBooks(booksJSON string) ([]BookSearch, bool) {
+	var books []BookSearch
+	if err := json.Unmarshal([]byte(booksJSON), &books); err != nil {
+		LogF("Ignoring unreadable search cache entry: %v", err)
+		return nil, false
+	}
+	return books, true
+}
+
+func (c *searchCache) touch(query string, pages int, sort string) {
+	if _, err := c.db.Exec(`UPDATE search_cache SET used_at = ? WHERE query = ? AND pages = ? AND sort = ?`,
+		time.Now().Unix(), query, pages, sort); err != nil {
+		LogF("Search cache update failed: %v", err)
+	}
+}
+
+// Put stores a result set and trims the cache to its size bound. fetchedAt
+// is when the books were fetched, so re-sorted entries keep their age.
+func (c *searchCache) Put(query string, pages int, sort string, books []BookSearch, fetchedAt time.Time) {
+	if c == nil {
+		return
+	}
+	booksJSON, err := json.Marshal(books)
+	if err != nil {
+		LogF("Search cache encoding failed: %v", err)
+		return
+	}
+	now := time.Now().Unix()
+	if _, err := c.db.Exec(`INSERT OR REPLACE INTO search_cache (query, pages, sort, books, fetched_at, used_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, query, pages, sort, string(booksJSON), fetchedAt.Unix(), now); err != nil {
+		LogF("Search cache write failed: %v", err)
+		return
+	}
+	c.prune()
+}
+
+// prune keeps the most recently used entries; expired ones stay until then,
+// as offline fallbacks
+func (c *searchCache) prune() {
+	if _, err := c.db.Exec(`DELETE FROM search_cache WHERE rowid NOT IN (
+		SELECT rowid FROM search_cache ORDER BY used_at DESC LIMIT ?)`, searchCacheEntries); err != nil {
+		LogF("Search cache pruning failed: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSearchCache(t *testing.T) {
+	searchCacheTTL = time.Hour
+	searchCacheEntries = 3
+	cache, err := openSearchCache(filepath.Join(t.TempDir(), "searchCache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	page1 := []BookSearch{{ID: 1, Title: "Dune", Found: 40}, {ID: 2, Title: "Dune Messiah", Found: 40}}
+	key := searchCacheKey("  Dune   Herbert ")
+	if key != "dune herbert" {
+		t.Errorf("searchCacheKey = %q", key)
+	}
+	cache.Put(key, 1, "", page1, time.Now())
+
+	if books, ok := cache.Get("dune herbert", 1, "", false); !ok || len(books) != 2 || books[1].Title != "Dune Messiah" {
+		t.Errorf("Get = %v, %v", books, ok)
+	}
+	if _, ok := cache.Get(key, 1, "y-", false); ok {
+		t.Errorf("another sort should miss")
+	}
+	// another sort or more pages start from the pages cached
+	if books, pages, _ := cache.Closest(key, 3); pages != 1 || len(books) != 2 {
+		t.Errorf("Closest = %v, %d pages", books, pages)
+	}
+
+	// expired entries only serve as offline fallbacks
+	cache.Put("old", 1, "", page1, time.Now().Add(-2*time.Hour))
+	if _, ok := cache.Get("old", 1, "", false); ok {
+		t.Errorf("expired entry served")
+	}
+	if _, pages, _ := cache.Closest("old", 1); pages != 0 {
+		t.Errorf("expired entry reused")
+	}
+	if _, ok := cache.Get("old", 1, "", true); !ok {
+		t.Errorf("expired entry missing as a fallback")
+	}
+
+	// the least recently used entries go first
+	cache.Put("a", 1, "", page1, time.Now())
+	cache.Put("b", 1, "", page1, time.Now())
+	var count int
+	if err := cache.db.QueryRow(`SELECT COUNT(*) FROM search_cache`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("cache holds %d entries, want 3", count)
+	}
+
+	// a nil cache is a cache that always misses
+	var missing *searchCache
+	if _, ok := missing.Get(key, 1, "", true); ok {
+		t.Errorf("nil cache hit")
+	}
+	missing.Put(key, 1, "", page1, time.Now())
+	missing.Close()
+}
+
+func TestSortSpecsKey(t *testing.T) {
+	_, specs, _ := extractSortFlags("--y --title- --m+")
+	if got := sortSpecsKey(specs); got != "y-,t-,m+" {
+		t.Errorf("sortSpecsKey = %q", got)
+	}
+}
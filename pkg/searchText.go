@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"unicode"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Search text normalization. Titles and author names are indexed, and
+// search words are matched, in the same folded form: "García Márquez",
+// "Brontë" and "O'Brien" are found by typing "garcia marquez", "bronte" and
+// "obrien". The library database registers the function for SQL as
+// normalize_search_text, so the index is built with exactly this code.
+
+// libraryDriverName is the SQLite driver with the workflow's SQL functions
+const libraryDriverName = "sqlite3_library"
+
+func init() {
+	sql.Register(libraryDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("normalize_search_text", normalizeSearchText, true)
+		},
+	})
+}
+
+// foldedLetters are letters Unicode does not decompose into a base letter
+// and a diacritic
+var foldedLetters = strings.NewReplacer(
+	"ø", "o", "Ø", "o",
+	"ł", "l", "Ł", "l",
+	"đ", "d", "Đ", "d",
+	"ð", "d", "Ð", "d",
+	"þ", "th", "Þ", "th",
+	"ß", "ss", "ẞ", "ss",
+	"æ", "ae", "Æ", "ae",
+	"œ", "oe", "Œ", "oe",
+	"ı", "i",
+)
+
+// isJoiningPunctuation reports the marks dropped without splitting a word:
+// apostrophes (O'Brien, l’amica) and periods inside initials (J.R.R.)
+func isJoiningPunctuation(r rune) bool {
+	switch r {
+	case '\'', '’', '‘', 'ʼ', '`', '´', '.':
+		return true
+	}
+	return false
+}
+
+// normalizeSearchText lower-cases text, removes diacritics and turns
+// punctuation into word breaks
+func normalizeSearchText(text string) string {
+	stripMarks := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(stripMarks, text)
+	if err != nil {
+		folded = text
+	}
+	folded = foldedLetters.Replace(folded)
+
+	var normalized strings.Builder
+	for _, r := range folded {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			normalized.WriteRune(unicode.ToLower(r))
+		case isJoiningPunctuation(r):
+		default:
+			normalized.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(normalized.String()), " ")
+}
@@ -0,0 +1,79 @@
+//go:build sqlite_fts5 || fts5
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFullTextIndexFolding runs typed searches against a real index; it
+// needs SQLite with FTS5 (go test -tags sqlite_fts5)
+func TestFullTextIndexFolding(t *testing.T) {
+	db, err := openLibraryDatabase(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	books := []struct {
+		id     int
+		title  string
+		author string
+	}{
+		{1, "Cien años de soledad", "Gabriel García Márquez"},
+		{2, "Wuthering Heights", "Emily Brontë"},
+		{3, "The Third Policeman", "Flann O'Brien"},
+		{4, "Slaughterhouse-Five", "Kurt Vonnegut"},
+		{5, "Преступление и наказание", "Фёдор Достоевский"},
+	}
+	for _, book := range books {
+		if _, err := db.Exec(`INSERT INTO books (book_id, title) VALUES (?, ?)`, book.id, book.title); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO author (book_id, name) VALUES (?, ?)`, book.id, book.author); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := createFTSTables(db); err != nil {
+		t.Fatal(err)
+	}
+
+	searches := map[string]int{
+		"anos soledad":          1,
+		"author:garcia marquez": 1,
+		"García":                1,
+		"bronte":                2,
+		"author:Brontë":         2,
+		"obrien":                3,
+		"O’Brien":               3,
+		"slaughterhouse five":   4,
+		"Slaughterhouse-Five":   4,
+		"федор":                 5,
+	}
+	for search, want := range searches {
+		query, err := parseLibraryQuery(search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clauses, args := query.compile()
+		ids, err := queryIDs(db, `SELECT b.book_id FROM books_authors_fts f
+			JOIN books b ON f.book_id = b.book_id
+			WHERE `+strings.Join(clauses, " AND "), args...)
+		if err != nil {
+			t.Fatalf("%q: %v", search, err)
+		}
+		if len(ids) != 1 || ids[0] != want {
+			t.Errorf("%q found %v, want [%d]", search, ids, want)
+		}
+	}
+
+	var display string
+	if err := db.QueryRow(`SELECT authors_display FROM books_authors_fts WHERE book_id = 1`).Scan(&display); err != nil {
+		t.Fatal(err)
+	}
+	if display != "Gabriel García Márquez" {
+		t.Errorf("authors_display = %q, want the name as written", display)
+	}
+}
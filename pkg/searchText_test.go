@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// multilingual titles and author names, with the words a user would type
+var searchTextFixtures = []struct {
+	text  string
+	typed string
+	want  string
+}{
+	{"Gabriel García Márquez", "garcia marquez", "gabriel garcia marquez"},
+	{"Cien años de soledad", "Cien anos", "cien anos de soledad"},
+	{"Emily Brontë", "bronte", "emily bronte"},
+	{"Flann O'Brien", "obrien", "flann obrien"},
+	{"L’amica geniale", "lamica", "lamica geniale"},
+	{"J.R.R. Tolkien", "jrr tolkien", "jrr tolkien"},
+	{"Slaughterhouse-Five", "slaughterhouse five", "slaughterhouse five"},
+	{"Peter Høeg", "hoeg", "peter hoeg"},
+	{"Stanisław Lem", "stanislaw", "stanislaw lem"},
+	{"Die Straße", "strasse", "die strasse"},
+	{"Ærø", "aero", "aero"},
+	{"Fyodor Dostoevsky — Преступление и наказание", "преступление", "fyodor dostoevsky преступление и наказание"},
+	{"Jalāl al-Dīn Rūmī", "jalal al din rumi", "jalal al din rumi"},
+	{"Ōe Kenzaburō", "oe kenzaburo", "oe kenzaburo"},
+	{"  Dune:  Messiah!  ", "dune messiah", "dune messiah"},
+}
+
+func TestNormalizeSearchText(t *testing.T) {
+	for _, fixture := range searchTextFixtures {
+		if got := normalizeSearchText(fixture.text); got != fixture.want {
+			t.Errorf("normalizeSearchText(%q) = %q, want %q", fixture.text, got, fixture.want)
+		}
+	}
+	for _, punctuation := range []string{"", "-", "...", "’"} {
+		if got := normalizeSearchText(punctuation); got != "" {
+			t.Errorf("normalizeSearchText(%q) = %q, want empty", punctuation, got)
+		}
+	}
+}
+
+func TestTypedSearchMatchesFixtures(t *testing.T) {
+	// every word typed, accented or not, is found among the indexed words
+	for _, fixture := range searchTextFixtures {
+		documents := []fuzzyDocument{{BookID: 1, Title: fixture.text}}
+		for _, typed := range []string{fixture.typed, fixture.text} {
+			terms := []queryTerm{{Field: fieldText, Value: typed}}
+			matches := rankFuzzyMatches(documents, terms)
+			if len(matches) != 1 || matches[0].Distance != 0 {
+				t.Errorf("%q should match %q exactly, got %v", typed, fixture.text, matches)
+			}
+		}
+	}
+}
+
+func TestQueryTextIsNormalized(t *testing.T) {
+	query, err := parseLibraryQuery(`author:"García Márquez" Brontë -O'Brien`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clauses, args := query.compile()
+	wantClauses := []string{
+		ftsMatchClause,
+		"b.book_id NOT IN (SELECT book_id FROM books_authors_fts WHERE books_authors_fts MATCH ?)",
+	}
+	if !reflect.DeepEqual(clauses, wantClauses) {
+		t.Errorf("clauses = %q, want %q", clauses, wantClauses)
+	}
+	wantArgs := []interface{}{`authors : "garcia marquez" "bronte" *`, `"obrien" *`}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %q, want %q", args, wantArgs)
+	}
+
+	// punctuation alone is not a search term
+	query, err = parseLibraryQuery(`dune - ... title:’`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(query.Terms) != 1 || query.Terms[0].Value != "dune" {
+		t.Errorf("terms = %+v, want only dune", query.Terms)
+	}
+}
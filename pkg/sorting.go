@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Sort flags, shared by library and catalog search. Each --key flag adds a
+// sort key; keys are applied in the order typed (--y --t: newest first, then
+// by title). A trailing + or - forces ascending or descending order.
+
+// sortKey describes one way of ordering books
+type sortKey struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Descending  bool   // natural direction
+	Column      string // SQL expression over books b
+}
+
+var sortKeys = []sortKey{
+	{Name: "y", Aliases: []string{"year"}, Description: "release year", Descending: true,
+		Column: "b.release_year"},
+	{Name: "r", Aliases: []string{"rating"}, Description: "Hardcover rating", Descending: true,
+		Column: "b.rating"},
+	{Name: "t", Aliases: []string{"title"}, Description: "title",
+		Column: "b.title COLLATE NOCASE"},
+	{Name: "m", Aliases: []string{"mine"}, Description: "my rating", Descending: true,
+		Column: "NULLIF(b.user_rating, 0)"},
+	{Name: "c", Aliases: []string{"count"}, Description: "number of ratings", Descending: true,
+		Column: "b.ratings_count"},
+	{Name: "a", Aliases: []string{"author"}, Description: "author surname",
+		Column: "b.author_sort"},
+	{Name: "d", Aliases: []string{"added"}, Description: "date added", Descending: true,
+		Column: "b.date_added"},
+	{Name: "s", Aliases: []string{"started"}, Description: "date started", Descending: true,
+		Column: "(SELECT MAX(j.started_at) FROM journey j WHERE j.user_book_id = b.user_book_id)"},
+	{Name: "f", Aliases: []string{"finished"}, Description: "date finished", Descending: true,
+		Column: "(SELECT MAX(j.finished_at) FROM journey j WHERE j.user_book_id = b.user_book_id)"},
+	{Name: "n", Aliases: []string{"shelves"}, Description: "number of shelves", Descending: true,
+		Column: "(SELECT COUNT(DISTINCT qs.shelf_id) FROM shelf qs WHERE qs.user_book_id = b.user_book_id)"},
+}
+
+// sortSpec is a key with the direction chosen for it
+type sortSpec struct {
+	Key        sortKey
+	Descending bool
+}
+
+// findSortKey returns the key with the given name or alias
+func findSortKey(name string) (sortKey, bool) {
+	for _, key := range sortKeys {
+		if strings.EqualFold(key.Name, name) {
+			return key, true
+		}
+		for _, alias := range key.Aliases {
+			if strings.EqualFold(alias, name) {
+				return key, true
+			}
+		}
+	}
+	return sortKey{}, false
+}
+
+// isFlagPrefix tells whether name starts the name or alias of a sort key,
+// or of a catalog filter (see catalogFilter.go)
+func isFlagPrefix(name string) bool {
+	var candidates []string
+	for _, key := range sortKeys {
+		candidates = append(append(candidates, key.Name), key.Aliases...)
+	}
+	for _, filter := range libraryFilters {
+		candidates = append(append(candidates, filter.Name), filter.Aliases...)
+	}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractSortFlags removes the --key flags from a search string and returns
+// them in order. A bare "--", or the start of a flag at the end of the
+// search ("--ye"), is still being typed and is dropped.
+func extractSortFlags(searchString string) (string, []sortSpec, error) {
+	var kept []string
+	var specs []sortSpec
+
+	tokens := strings.Fields(searchString)
+	typing := !strings.HasSuffix(searchString, " ")
+	for i, token := range tokens {
+		if !strings.HasPrefix(token, "--") {
+			kept = append(kept, token)
+			continue
+		}
+
+		name := strings.TrimPrefix(token, "--")
+		if name == "" {
+			continue
+		}
+		direction := name[len(name)-1]
+		if direction == '+' || direction == '-' {
+			name = name[:len(name)-1]
+		}
+
+		key, found := findSortKey(name)
+		if !found && typing && i == len(tokens)-1 && isFlagPrefix(name) {
+			continue
+		}
+		if !found {
+			return searchString, nil, fmt.Errorf("unknown sort flag %q, try %s", token, sortFlagList())
+		}
+		spec := sortSpec{Key: key, Descending: key.Descending}
+		switch direction {
+		case '+':
+			spec.Descending = false
+		case '-':
+			spec.Descending = true
+		}
+		specs = append(specs, spec)
+	}
+	search := strings.Join(kept, " ")
+	if !typing && search != "" {
+		// the query parser tells finished terms by the trailing space
+		search += " "
+	}
+	return search, specs, nil
+}
+
+// sortFlagList lists the flags for error messages
+func sortFlagList() string {
+	var flags []string
+	for _, key := range sortKeys {
+		flags = append(flags, "--"+key.Name+" ("+key.Description+")")
+	}
+	return strings.Join(flags, ", ")
+}
+
+// orderByClause compiles the sort keys for the library query; books without
+// a value for a key always come last
+func orderByClause(specs []sortSpec) string {
+	if len(specs) == 0 {
+		return ""
+	}
+	var terms []string
+	for _, spec := range specs {
+		direction := "ASC"
+		if spec.Descending {
+			direction = "DESC"
+		}
+		terms = append(terms, spec.Key.Column+" "+direction+" NULLS LAST")
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// catalogSortValue returns the value of a key for a catalog result, using
+// the library copy of the book when there is one. ok is false when the book
+// has no value for the key.
+func catalogSortValue(key sortKey, book BookSearch, info BookInfoMap, inLibrary bool) (number float64, text string, ok bool) {
+	switch key.Name {
+	case "y":
+		return float64(book.ReleaseYear), "", book.ReleaseYear != 0
+	case "r":
+		return book.Rating, "", true
+	case "t":
+		return 0, strings.ToLower(book.Title), true
+	case "c":
+		return float64(book.Raters), "", true
+	case "a":
+		firstAuthor, _, _ := strings.Cut(book.Authors, ", ")
+		surname := authorSurname(firstAuthor)
+		return 0, surname, surname != ""
+	case "m":
+		return info.UserRating, "", inLibrary && info.UserRating > 0
+	case "d":
+		return 0, info.DateAdded, info.DateAdded != ""
+	case "s":
+		return 0, info.StartedAt, info.StartedAt != ""
+	case "f":
+		return 0, info.FinishedAt, info.FinishedAt != ""
+	case "n":
+		if info.ShelfCount == 0 {
+			return 0, "", inLibrary
+		}
+		return float64(info.ShelfCount), "", true
+	}
+	return 0, "", false
+}
+
+// sortCatalogBooks orders catalog results by the given keys
+func sortCatalogBooks(books []BookSearch, library map[int]BookInfoMap, specs []sortSpec) {
+	if len(specs) == 0 {
+		return
+	}
+	sort.SliceStable(books, func(i, j int) bool {
+		infoI, inLibraryI := library[books[i].ID]
+		infoJ, inLibraryJ := library[books[j].ID]
+		for _, spec := range specs {
+			numberI, textI, okI := catalogSortValue(spec.Key, books[i], infoI, inLibraryI)
+			numberJ, textJ, okJ := catalogSortValue(spec.Key, books[j], infoJ, inLibraryJ)
+			if okI != okJ {
+				return okI // missing values last
+			}
+			if !okI || (numberI == numberJ && textI == textJ) {
+				continue
+			}
+			var less bool
+			if textI != textJ {
+				less = textI < textJ
+			} else {
+				less = numberI < numberJ
+			}
+			if spec.Descending {
+				return !less
+			}
+			return less
+		}
+		return false
+	})
+}
+
+// nameSuffixes are dropped before taking the surname
+var nameSuffixes = map[string]bool{
+	"jr": true, "jr.": true, "sr": true, "sr.": true,
+	"ii": true, "iii": true, "iv": true, "phd": true, "ph.d.": true,
+}
+
+// authorSurname returns the lower-cased sort name of an author:
+// "J.R.R. Tolkien" and "Tolkien, J.R.R." both give "tolkien"
+func authorSurname(name string) string {
+	name = strings.TrimSpace(name)
+	if surname, _, inverted := strings.Cut(name, ","); inverted {
+		if rest := strings.TrimSpace(name[len(surname)+1:]); !nameSuffixes[strings.ToLower(rest)] {
+			return strings.ToLower(strings.TrimSpace(surname))
+		}
+		name = surname
+	}
+
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+	for len(words) > 1 && nameSuffixes[strings.ToLower(words[len(words)-1])] {
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	return strings.ToLower(words[len(words)-1])
+}
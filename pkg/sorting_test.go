@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractSortFlags(t *testing.T) {
+	search, specs, err := extractSortFlags("dune --y --title+ herbert --mine- --")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if search != "dune herbert" {
+		t.Errorf("search = %q, want %q", search, "dune herbert")
+	}
+
+	var got []string
+	for _, spec := range specs {
+		direction := "+"
+		if spec.Descending {
+			direction = "-"
+		}
+		got = append(got, spec.Key.Name+direction)
+	}
+	if want := []string{"y-", "t+", "m-"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("specs = %v, want %v", got, want)
+	}
+
+	if _, _, err := extractSortFlags("dune --z"); err == nil {
+		t.Errorf("unknown flag should fail")
+	}
+
+	// a flag still being typed is not an error yet
+	search, specs, err = extractSortFlags("dune --t --ye")
+	if err != nil || search != "dune" || len(specs) != 1 {
+		t.Errorf("unfinished flag: search = %q, %d specs, err = %v", search, len(specs), err)
+	}
+	if _, _, err := extractSortFlags("dune --ye "); err == nil {
+		t.Errorf("finished unknown flag should fail")
+	}
+	if search, _, _ := extractSortFlags("dune --t rating:> "); search != "dune rating:> " {
+		t.Errorf("search = %q, want the trailing space kept", search)
+	}
+}
+
+func TestOrderByClause(t *testing.T) {
+	_, specs, _ := extractSortFlags("--y --t")
+	want := " ORDER BY b.release_year DESC NULLS LAST, b.title COLLATE NOCASE ASC NULLS LAST"
+	if got := orderByClause(specs); got != want {
+		t.Errorf("orderByClause = %q, want %q", got, want)
+	}
+	if got := orderByClause(nil); got != "" {
+		t.Errorf("orderByClause(nil) = %q, want empty", got)
+	}
+}
+
+func TestAuthorSurname(t *testing.T) {
+	tests := map[string]string{
+		"J.R.R. Tolkien":          "tolkien",
+		"Tolkien, J.R.R.":         "tolkien",
+		"Martin Luther King, Jr.": "king",
+		"Kurt Vonnegut Jr.":       "vonnegut",
+		"Homer":                   "homer",
+		"  ":                      "",
+	}
+	for name, want := range tests {
+		if got := authorSurname(name); got != want {
+			t.Errorf("authorSurname(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSortCatalogBooks(t *testing.T) {
+	books := []BookSearch{
+		{ID: 1, Title: "B", Authors: "Ursula Vernon", ReleaseYear: 1969},
+		{ID: 2, Title: "A", Authors: "Frank Herbert", ReleaseYear: 1965},
+		{ID: 3, Title: "C", Authors: "Isaac Asimov", ReleaseYear: 1969},
+		{ID: 4, Title: "D", Authors: "Anonymous"},
+	}
+	library := map[int]BookInfoMap{
+		2: {UserRating: 4},
+		3: {UserRating: 5},
+	}
+	order := func() []int {
+		var ids []int
+		for _, book := range books {
+			ids = append(ids, book.ID)
+		}
+		return ids
+	}
+
+	_, specs, _ := extractSortFlags("--y --t-")
+	sortCatalogBooks(books, library, specs)
+	if want := []int{3, 1, 2, 4}; !reflect.DeepEqual(order(), want) {
+		t.Errorf("year then title desc = %v, want %v", order(), want)
+	}
+
+	_, specs, _ = extractSortFlags("--a")
+	sortCatalogBooks(books, library, specs)
+	if want := []int{4, 3, 2, 1}; !reflect.DeepEqual(order(), want) {
+		t.Errorf("author = %v, want %v", order(), want)
+	}
+
+	// books without a rating of mine come last
+	_, specs, _ = extractSortFlags("--m")
+	sortCatalogBooks(books, library, specs)
+	if got := order(); got[0] != 3 || got[1] != 2 {
+		t.Errorf("my rating = %v, want 3 and 2 first", got)
+	}
+
+	// a shelf name with a comma is still one shelf
+	library[2] = BookInfoMap{Shelves: "sci-fi, fantasy, space opera", ShelfCount: 1}
+	library[3] = BookInfoMap{Shelves: "classics, robots", ShelfCount: 2}
+	_, specs, _ = extractSortFlags("--n")
+	sortCatalogBooks(books, library, specs)
+	if got := order(); got[0] != 3 || got[1] != 2 {
+		t.Errorf("shelf count = %v, want 3 and 2 first", got)
+	}
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// syncOverlap re-fetches a little before the last sync, so that clock skew
+// between this machine and Hardcover cannot lose a change. Upserts are
+// idempotent, so seeing a row twice is harmless.
+const syncOverlap = 2 * time.Minute
+
+// deletionCheckInterval is how often a sync also pages through the IDs of
+// the whole library to find what was deleted remotely; -sync always does
+const deletionCheckInterval = 24 * time.Hour
+
+// errNoSyncState means there is nothing to sync from: a full rebuild is needed
+var errNoSyncState = errors.New("no previous sync recorded")
+
+// libraryDelta holds everything that changed remotely since the last sync
+type libraryDelta struct {
+	UserBooks []UserBook
+	ListBooks []ListBook
+	Reads     []UserBookRead
+
+	// IDs that still exist remotely, used to detect deletions; only
+	// fetched when CheckedDeletions is set
+	CheckedDeletions bool
+	RemoteUserBooks  map[int]bool
+	RemoteReads      map[int]bool
+	RemoteListBooks  map[int]bool
+}
+
+// saveLastSync records the point from which the next incremental sync starts
+func saveLastSync(syncStart time.Time) {
+	fileName := filepath.Join(dataFolder, "lastSyncedAt")
+	err := os.WriteFile(fileName, []byte(syncStart.UTC().Format(time.RFC3339)), 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save sync time to file:", err)
+	}
+}
+
+// requestFullSync makes the next sync fetch every library book, read and
+// shelf entry instead of the changes only, e.g. to fill the columns a
+// migration added
+func requestFullSync() {
+	fileName := filepath.Join(dataFolder, "fullSyncPending")
+	if err := os.WriteFile(fileName, nil, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save full sync request to file:", err)
+	}
+}
+
+// fullSyncPending tells whether requestFullSync was called since the last
+// complete sync or rebuild
+func fullSyncPending() bool {
+	_, err := os.Stat(filepath.Join(dataFolder, "fullSyncPending"))
+	return err == nil
+}
+
+// clearFullSync drops the request once the whole library was fetched
+func clearFullSync() {
+	err := os.Remove(filepath.Join(dataFolder, "fullSyncPending"))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, "Failed to remove the full sync request file:", err)
+	}
+}
+
+// deletionCheckDue tells whether the last deletion check is older than
+// deletionCheckInterval
+func deletionCheckDue(now time.Time) bool {
+	data, err := os.ReadFile(filepath.Join(dataFolder, "lastDeletionCheck"))
+	if err != nil {
+		return true
+	}
+	lastCheck, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	return err != nil || now.Sub(lastCheck) >= deletionCheckInterval
+}
+
+// saveDeletionCheck records when the remote IDs were last fetched
+func saveDeletionCheck(checkStart time.Time) {
+	fileName := filepath.Join(dataFolder, "lastDeletionCheck")
+	err := os.WriteFile(fileName, []byte(checkStart.UTC().Format(time.RFC3339)), 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save deletion check time to file:", err)
+	}
+}
+
+// readLastSync returns the start time of the last successful sync
+func readLastSync() (time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(dataFolder, "lastSyncedAt"))
+	if err != nil {
+		return time.Time{}, errNoSyncState
+	}
+	lastSync, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, errNoSyncState
+	}
+	return lastSync, nil
+}
+
+// syncLibraryDatabase applies the changes made on Hardcover since the last
+// successful sync to the local database, in place and in one transaction.
+// Deletions are looked for once per deletionCheckInterval, or always with
+// checkDeletions; everything is fetched again when fullSyncPending. It returns the number of rows it changed, or
+// errNoSyncState when only a full rebuild (createLibraryDatabase) can bring
+// the database up to date.
+func syncLibraryDatabase(checkDeletions bool) (int, error) {
+	startTime := time.Now()
+
+	lastSync, err := readLastSync()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(databasePath); err != nil {
+		return 0, errNoSyncState
+	}
+
+	// opened first: its migrations may request a full sync
+	db, err := openLibraryDatabase(databasePath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	since := lastSync.Add(-syncOverlap)
+	fullSync := fullSyncPending()
+	if fullSync {
+		since = time.Time{}
+	}
+	checkDeletions = checkDeletions || deletionCheckDue(startTime)
+	delta, err := fetchLibraryDelta(since, checkDeletions)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	changes, err := applyLibraryDelta(tx, delta)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit sync: %w", err)
+	}
+
+	covers.Wait()
+	saveLastUpdatedLocal()
+	saveLastSync(startTime)
+	if checkDeletions {
+		saveDeletionCheck(startTime)
+	}
+	if fullSync {
+		clearFullSync()
+	}
+
+	elapsedTime := time.Since(startTime)
+	LogF("Database sync: %d changes in %d ms", changes, elapsedTime.Milliseconds())
+	return changes, nil
+}
+
+// fetchLibraryDelta downloads the rows changed since the given time, and
+// with checkDeletions the IDs of everything that still exists remotely
+func fetchLibraryDelta(since time.Time, checkDeletions bool) (libraryDelta, error) {
+	delta := libraryDelta{
+		CheckedDeletions: checkDeletions,
+		RemoteUserBooks:  make(map[int]bool),
+		RemoteReads:      make(map[int]bool),
+		RemoteListBooks:  make(map[int]bool),
+	}
+	changedVariables := graphQLVars{
+		"userID": userID,
+		"since":  since.UTC().Format(time.RFC3339),
+	}
+
+	err := fetchAllPages(changedUserBooksQuery, changedVariables, libraryPageSize, func(body []byte) (int, error) {
+		var APILibrary APILibrary
+		if err := json.Unmarshal(body, &APILibrary); err != nil {
+			return 0, fmt.Errorf("error decoding library JSON response: %w", err)
+		}
+		delta.UserBooks = append(delta.UserBooks, APILibrary.Data.UserBooks...)
+		return len(APILibrary.Data.UserBooks), nil
+	})
+	if err != nil {
+		return delta, err
+	}
+
+	err = fetchAllPages(changedShelfBooksQuery, changedVariables, shelfPageSize, func(body []byte) (int, error) {
+		var APIListBooks APIListBooks
+		if err := json.Unmarshal(body, &APIListBooks); err != nil {
+			return 0, fmt.Errorf("error decoding shelf JSON response: %w", err)
+		}
+		delta.ListBooks = append(delta.ListBooks, APIListBooks.Data.ListBooks...)
+		return len(APIListBooks.Data.ListBooks), nil
+	})
+	if err != nil {
+		return delta, err
+	}
+
+	body, err := interrogateAPI(newGraphQLRequest(changedReadsQuery, changedVariables))
+	if err != nil {
+		return delta, err
+	}
+	var readsResponse struct {
+		Data struct {
+			UserBookReads []UserBookRead `json:"user_book_reads"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &readsResponse); err != nil {
+		return delta, fmt.Errorf("error decoding reads JSON response: %w", err)
+	}
+	delta.Reads = readsResponse.Data.UserBookReads
+	if !checkDeletions {
+		return delta, nil
+	}
+
+	err = fetchAllPages(libraryIDsQuery, graphQLVars{"userID": userID}, libraryPageSize, func(body []byte) (int, error) {
+		var idsResponse struct {
+			Data struct {
+				UserBooks []struct {
+					ID            int `json:"id"`
+					UserBookReads []struct {
+						ID int `json:"id"`
+					} `json:"user_book_reads"`
+				} `json:"user_books"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &idsResponse); err != nil {
+			return 0, fmt.Errorf("error decoding library IDs: %w", err)
+		}
+		for _, userBook := range idsResponse.Data.UserBooks {
+			delta.RemoteUserBooks[userBook.ID] = true
+			for _, read := range userBook.UserBookReads {
+				delta.RemoteReads[read.ID] = true
+			}
+		}
+		return len(idsResponse.Data.UserBooks), nil
+	})
+	if err != nil {
+		return delta, err
+	}
+
+	err = fetchAllPages(shelfIDsQuery, graphQLVars{"userID": userID}, shelfPageSize, func(body []byte) (int, error) {
+		var idsResponse struct {
+			Data struct {
+				ListBooks []struct {
+					ID int `json:"id"`
+				} `json:"list_books"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &idsResponse); err != nil {
+			return 0, fmt.Errorf("error decoding shelf IDs: %w", err)
+		}
+		for _, listBook := range idsResponse.Data.ListBooks {
+			delta.RemoteListBooks[listBook.ID] = true
+		}
+		return len(idsResponse.Data.ListBooks), nil
+	})
+
+	return delta, err
+}
+
+// applyLibraryDelta upserts and deletes rows in place, then refreshes only
+// the derived tables the changes affected
+func applyLibraryDelta(tx *sql.Tx, delta libraryDelta) (int, error) {
+	changes := 0
+	affectedBooks := make(map[int]bool)
+	ratingsChanged := false
+	shelvesChanged := false
+
+	for _, userBook := range delta.UserBooks {
+		if err := upsertUserBook(tx, userBook); err != nil {
+			return changes, err
+		}
+		affectedBooks[userBook.Book.ID] = true
+		ratingsChanged = true
+		changes++
+	}
+
+	for _, read := range delta.Reads {
+		_, err := tx.Exec(
+			`INSERT OR REPLACE INTO journey (journey_id, user_book_id, started_at, finished_at, progress_pages, progress_seconds)
+			SELECT ?, user_book_id, ?, ?, ?, ? FROM books WHERE user_book_id = ?`,
+			read.ID, read.StartedAt, read.FinishedAt, read.ProgressPages, read.ProgressSeconds, read.UserBookID,
+		)
+		if err != nil {
+			return changes, fmt.Errorf("failed to store read %d: %w", read.ID, err)
+		}
+		changes++
+	}
+
+	// deletions, when the remote IDs were fetched
+	if delta.CheckedDeletions {
+		// reads deleted remotely
+		staleReads, err := queryIDs(tx, `SELECT journey_id FROM journey`)
+		if err != nil {
+			return changes, err
+		}
+		for _, readID := range staleReads {
+			if delta.RemoteReads[readID] {
+				continue
+			}
+			if _, err := tx.Exec(`DELETE FROM journey WHERE journey_id = ?`, readID); err != nil {
+				return changes, fmt.Errorf("failed to delete read %d: %w", readID, err)
+			}
+			changes++
+		}
+
+		// user_books deleted remotely
+		rows, err := tx.Query(`SELECT book_id, user_book_id FROM books WHERE user_book_id > 0`)
+		if err != nil {
+			return changes, fmt.Errorf("failed to list library books: %w", err)
+		}
+		removedBooks := make(map[int]int)
+		for rows.Next() {
+			var bookID, userBookID int
+			if err := rows.Scan(&bookID, &userBookID); err != nil {
+				rows.Close()
+				return changes, fmt.Errorf("failed to scan row: %w", err)
+			}
+			if !delta.RemoteUserBooks[userBookID] {
+				removedBooks[bookID] = userBookID
+			}
+		}
+		rows.Close()
+		for bookID, userBookID := range removedBooks {
+			if err := removeUserBook(tx, bookID, userBookID); err != nil {
+				return changes, err
+			}
+			affectedBooks[bookID] = true
+			ratingsChanged = true
+			changes++
+		}
+	}
+
+	for _, listBook := range delta.ListBooks {
+		if err := upsertListBook(tx, listBook); err != nil {
+			return changes, err
+		}
+		affectedBooks[listBook.BookID] = true
+		shelvesChanged = true
+		changes++
+	}
+
+	if delta.CheckedDeletions {
+		// list_books deleted remotely
+		staleListBooks, err := queryIDs(tx, `SELECT list_book_id FROM shelf`)
+		if err != nil {
+			return changes, err
+		}
+		for _, listBookID := range staleListBooks {
+			if delta.RemoteListBooks[listBookID] {
+				continue
+			}
+			bookID, err := removeListBook(tx, listBookID)
+			if err != nil {
+				return changes, err
+			}
+			affectedBooks[bookID] = true
+			shelvesChanged = true
+			changes++
+		}
+	}
+
+	// shelves are cheap to fetch and may have been renamed
+	if err := storeBookshelves(tx); err != nil {
+		return changes, err
+	}
+	if _, err := tx.Exec(`UPDATE shelf SET name = (SELECT name FROM bookshelves WHERE bookshelves.shelf_id = shelf.shelf_id)
+		WHERE shelf_id IN (SELECT shelf_id FROM bookshelves)`); err != nil {
+		return changes, fmt.Errorf("failed to rename shelves: %w", err)
+	}
+
+	// derived tables
+	if shelvesChanged {
+		if err := updateBookShelves(tx); err != nil {
+			return changes, err
+		}
+	}
+	if ratingsChanged {
+		if err := createRatingstable(tx); err != nil {
+			return changes, err
+		}
+	}
+	var bookIDs []int
+	for bookID := range affectedBooks {
+		bookIDs = append(bookIDs, bookID)
+	}
+	sort.Ints(bookIDs)
+	if err := refreshFTSRows(tx, bookIDs); err != nil {
+		return changes, err
+	}
+
+	return changes, nil
+}
+
+// removeUserBook drops a book removed from the library. If it is still on a
+// shelf it stays, as a shelf-only book without status or rating.
+func removeUserBook(tx *sql.Tx, bookID, userBookID int) error {
+	if _, err := tx.Exec(`DELETE FROM journey WHERE user_book_id = ?`, userBookID); err != nil {
+		return fmt.Errorf("failed to delete journey of book %d: %w", bookID, err)
+	}
+
+	var shelfEntries int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM shelf WHERE user_book_id = ?`, userBookID).Scan(&shelfEntries); err != nil {
+		return fmt.Errorf("failed to count shelves of book %d: %w", bookID, err)
+	}
+
+	if shelfEntries == 0 {
+		return deleteBookRows(tx, bookID)
+	}
+
+	var shelfOnlyID int
+	if err := tx.QueryRow(`SELECT MIN(IFNULL(MIN(user_book_id), 0), 0) - 1 FROM books`).Scan(&shelfOnlyID); err != nil {
+		return fmt.Errorf("failed to allocate user_book_id: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE shelf SET user_book_id = ? WHERE user_book_id = ?`, shelfOnlyID, userBookID); err != nil {
+		return fmt.Errorf("failed to move shelves of book %d: %w", bookID, err)
+	}
+	_, err := tx.Exec(`UPDATE books SET user_book_id = ?, status_id = 0, user_rating = NULL WHERE book_id = ?`, shelfOnlyID, bookID)
+	if err != nil {
+		return fmt.Errorf("failed to demote book %d: %w", bookID, err)
+	}
+	return nil
+}
+
+// removeListBook drops a shelf entry, and the book itself when it was only
+// in the library because of that shelf. It returns the affected book_id.
+func removeListBook(tx *sql.Tx, listBookID int) (int, error) {
+	var bookID, userBookID int
+	err := tx.QueryRow(`SELECT b.book_id, b.user_book_id FROM shelf s
+		JOIN books b ON b.user_book_id = s.user_book_id
+		WHERE s.list_book_id = ?`, listBookID).Scan(&bookID, &userBookID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to look up shelf entry %d: %w", listBookID, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM shelf WHERE list_book_id = ?`, listBookID); err != nil {
+		return 0, fmt.Errorf("failed to delete shelf entry %d: %w", listBookID, err)
+	}
+
+	if userBookID < 0 {
+		var remaining int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM shelf WHERE user_book_id = ?`, userBookID).Scan(&remaining); err != nil {
+			return 0, fmt.Errorf("failed to count shelves of book %d: %w", bookID, err)
+		}
+		if remaining == 0 {
+			return bookID, deleteBookRows(tx, bookID)
+		}
+	}
+	return bookID, nil
+}
+
+// deleteBookRows removes a book and its authors
+func deleteBookRows(tx *sql.Tx, bookID int) error {
+	if _, err := tx.Exec(`DELETE FROM author WHERE book_id = ?`, bookID); err != nil {
+		return fmt.Errorf("failed to delete authors of book %d: %w", bookID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM books WHERE book_id = ?`, bookID); err != nil {
+		return fmt.Errorf("failed to delete book %d: %w", bookID, err)
+	}
+	return nil
+}
+
+// queryIDs runs a query returning a single integer column
+func queryIDs(db sqlExecutor, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// serveSyncResult runs an incremental sync, deletions included, and reports
+// it as an Alfred item
+func serveSyncResult() {
+	changes, err := syncLibraryDatabase(true)
+	if err == errNoSyncState {
+		createLibraryDatabase()
+		return
+	}
+	if err != nil {
+		serveErrorItem("Library sync failed", err)
+		return
+	}
+
+	result := map[string][]map[string]interface{}{
+		"items": {map[string]interface{}{
+			"title":    "Done!",
+			"subtitle": fmt.Sprintf("Library synced: %d changes applied.", changes),
+			"valid":    true,
+			"icon": map[string]string{
+				"path": "icons/done.png",
+			},
+			"arg": "",
+		}},
+	}
+	jsonResult, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		LogF("Error encoding JSON: %v", err)
+		return
+	}
+	fmt.Println(string(jsonResult))
+}